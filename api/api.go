@@ -0,0 +1,356 @@
+// Package api serves a REST API to search, add and manage shows. It runs
+// next to the daemon, which does the actual refreshing and searching.
+//
+// Every request needs an 'Authorization: Bearer <token>' header, except the
+// OpenAPI description at /api/openapi.json.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/daemon"
+	"github.com/haarts/getme/sources"
+	"github.com/haarts/getme/store"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Server handles the API requests.
+type Server struct {
+	store  *store.Store
+	daemon *daemon.Daemon
+	token  string
+	search func(context.Context, string) []sources.SearchResult
+	lookup func(context.Context, *store.Show) error
+}
+
+// New returns a server for the shows in s. Updates are handed to d, which
+// may be nil in which case updates can't be triggered.
+func New(s *store.Store, d *daemon.Daemon, token string) *Server {
+	return &Server{
+		store:  s,
+		daemon: d,
+		token:  token,
+		search: sources.Search,
+		lookup: lookup,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("API request")
+
+	if r.URL.Path == "/api/openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+		return
+	}
+
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/api/search" && r.Method == "GET":
+		s.handleSearch(w, r)
+	case r.URL.Path == "/api/shows" && r.Method == "GET":
+		s.handleListShows(w, r)
+	case r.URL.Path == "/api/shows" && r.Method == "POST":
+		s.handleAddShow(w, r)
+	case r.URL.Path == "/api/update" && r.Method == "POST":
+		s.handleUpdateAll(w, r)
+	case r.URL.Path == "/api/snatches" && r.Method == "GET":
+		s.handleSnatches(w, r)
+	case r.URL.Path == "/api/failed" && r.Method == "POST":
+		s.handleFailed(w, r)
+	case strings.HasPrefix(r.URL.EscapedPath(), "/api/shows/"):
+		s.routeShow(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+// routeShow handles /api/shows/{title} and the actions below it. The title
+// is path escaped.
+func (s *Server) routeShow(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/shows/"), "/")
+	title, err := url.PathUnescape(segments[0])
+	if err != nil || len(segments) > 2 {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+		return
+	}
+
+	var action string
+	if len(segments) == 2 {
+		action = segments[1]
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	show, ok := s.store.Shows()[title]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such show")
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, detailOf(show, time.Now()))
+	case action == "" && r.Method == "DELETE":
+		if err := s.store.RemoveShow(title); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case (action == "pause" || action == "resume") && r.Method == "POST":
+		show.Paused = action == "pause"
+		if err := s.store.Save(show); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !show.Paused && s.daemon != nil {
+			s.daemon.Wake()
+		}
+		writeJSON(w, http.StatusOK, summaryOf(show))
+	case action == "settings" && r.Method == "POST":
+		var settings map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid settings: "+err.Error())
+			return
+		}
+		for setting, value := range settings {
+			if err := show.Set(setting, value); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if err := s.store.Save(show); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, summaryOf(show))
+	case action == "update" && r.Method == "POST":
+		if s.daemon == nil {
+			writeError(w, http.StatusServiceUnavailable, "updates need the daemon")
+			return
+		}
+		s.daemon.RefreshNow(show)
+		writeJSON(w, http.StatusAccepted, summaryOf(show))
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+type searchResult struct {
+	Source string      `json:"source"`
+	Shows  []foundShow `json:"shows"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type foundShow struct {
+	Title  string `json:"title"`
+	ID     int    `json:"id"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+	Year   int    `json:"year"`
+	Anime  bool   `json:"anime"`
+	Daily  bool   `json:"daily"`
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "missing query parameter q")
+		return
+	}
+
+	results := []searchResult{}
+	for _, result := range s.search(r.Context(), query) {
+		converted := searchResult{Source: result.Name, Shows: []foundShow{}}
+		if result.Error != nil {
+			converted.Error = result.Error.Error()
+		}
+		for _, show := range result.Shows {
+			converted.Shows = append(converted.Shows, foundShow{
+				Title:  show.Title,
+				ID:     show.ID,
+				URL:    show.URL,
+				Source: show.Source,
+				Year:   show.Year,
+				Anime:  show.Anime,
+				Daily:  show.Daily,
+			})
+		}
+		results = append(results, converted)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) handleListShows(w http.ResponseWriter, r *http.Request) {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	shows := []showSummary{}
+	for _, show := range s.store.Shows() {
+		shows = append(shows, summaryOf(show))
+	}
+	sort.Sort(byTitle(shows))
+	writeJSON(w, http.StatusOK, shows)
+}
+
+// handleAddShow adds a show found with a search. The seasons and episodes
+// are looked up before the show is stored, the daemon searches for the
+// torrents.
+func (s *Server) handleAddShow(w http.ResponseWriter, r *http.Request) {
+	var found foundShow
+	if err := json.NewDecoder(r.Body).Decode(&found); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid show: "+err.Error())
+		return
+	}
+	if found.Title == "" || found.Source == "" || found.ID == 0 {
+		writeError(w, http.StatusBadRequest, "title, source and id are required")
+		return
+	}
+	if !sources.IsAvailable(found.Source) {
+		writeError(w, http.StatusBadRequest, "unknown source "+found.Source)
+		return
+	}
+
+	show := s.store.NewShow(found.Source, found.ID, found.URL, found.Title)
+	show.Anime = found.Anime
+	if found.Daily {
+		show.Daily = &found.Daily
+	}
+	if err := s.lookup(r.Context(), show); err != nil {
+		writeError(w, http.StatusBadGateway, "looking up seasons failed: "+err.Error())
+		return
+	}
+	if len(show.Episodes()) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "no episodes could be found for show")
+		return
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	if err := s.store.CreateShow(show); err != nil {
+		writeError(w, http.StatusConflict, "show already exists")
+		return
+	}
+	if err := s.store.Save(show); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.daemon != nil {
+		s.daemon.Wake()
+	}
+
+	writeJSON(w, http.StatusCreated, detailOf(show, time.Now()))
+}
+
+func (s *Server) handleUpdateAll(w http.ResponseWriter, r *http.Request) {
+	if s.daemon == nil {
+		writeError(w, http.StatusServiceUnavailable, "updates need the daemon")
+		return
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	for _, show := range s.store.Shows() {
+		s.daemon.RefreshNow(show)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleSnatches(w http.ResponseWriter, r *http.Request) {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	snatches, err := s.store.Snatches()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, snatches)
+}
+
+// failedTorrent is what a download client reports about a torrent it
+// failed to download. Either field identifies the torrent.
+type failedTorrent struct {
+	InfoHash string `json:"info_hash"`
+	Torrent  string `json:"torrent"`
+}
+
+// handleFailed blocks a torrent the download client failed to download and
+// has the daemon search for its episodes again.
+func (s *Server) handleFailed(w http.ResponseWriter, r *http.Request) {
+	var failed failedTorrent
+	if err := json.NewDecoder(r.Body).Decode(&failed); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid torrent: "+err.Error())
+		return
+	}
+	if failed.InfoHash == "" && failed.Torrent == "" {
+		writeError(w, http.StatusBadRequest, "info_hash or torrent is required")
+		return
+	}
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	blocked, err := s.store.DownloadFailed(failed.InfoHash, failed.Torrent, "download client reported failure", time.Now())
+	if errors.Is(err, store.ErrNotSnatched) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.daemon != nil {
+		s.daemon.Wake()
+	}
+	writeJSON(w, http.StatusOK, blocked)
+}
+
+// lookup fetches the seasons and episodes of a new show, like ui.Lookup
+// without the feedback on stdout.
+func lookup(ctx context.Context, show *store.Show) error {
+	sources.Link(ctx, show)
+	return sources.UpdateSeasonsAndEpisodes(ctx, show)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to write API response.")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
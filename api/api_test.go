@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/config"
+	"github.com/haarts/getme/daemon"
+	"github.com/haarts/getme/sources"
+	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "test_state_dir"
+
+func testServer(t *testing.T) (*Server, *store.Store) {
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+
+	s, err := store.Open(testDir)
+	require.NoError(t, err)
+	s.CreateShow(&store.Show{
+		Title: "Dead Set",
+		Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+			{Episode: 1, AirDate: time.Date(2008, 10, 27, 0, 0, 0, 0, time.UTC)},
+			{Episode: 2, Pending: true, AirDate: time.Date(2008, 10, 28, 0, 0, 0, 0, time.UTC)},
+		}}},
+	})
+
+	server := New(s, daemon.New(s, &config.Conf{}), "secret")
+	server.search = func(context.Context, string) []sources.SearchResult {
+		return []sources.SearchResult{{Name: "tvmaze", Shows: []sources.Show{
+			{Title: "Sherlock", ID: 335, Source: "tvmaze", Year: 2010},
+		}}}
+	}
+	server.lookup = func(_ context.Context, show *store.Show) error {
+		show.Seasons = []*store.Season{{Season: 1, Episodes: []*store.Episode{{Episode: 1, Pending: true}}}}
+		return nil
+	}
+	return server, s
+}
+
+func do(server *Server, method, URL, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, URL, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenIsRequired(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	server, _ := testServer(t)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/shows", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, json.Valid(w.Body.Bytes()))
+}
+
+func TestShowEpisodeStates(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	server, _ := testServer(t)
+
+	w := do(server, "GET", "/api/shows/Dead%20Set", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var detail showDetail
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Equal(t, 1, detail.PendingEpisodes)
+	assert.Equal(t, store.EpisodeDownloaded, detail.Seasons[0].Episodes[0].State)
+	assert.Equal(t, store.EpisodeWanted, detail.Seasons[0].Episodes[1].State)
+}
+
+func TestAddPauseAndRemoveShow(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	server, s := testServer(t)
+
+	w := do(server, "GET", "/api/search?q=sherlock", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"title":"Sherlock"`)
+
+	w = do(server, "POST", "/api/shows", `{"title":"Sherlock","id":335,"source":"tvmaze"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	_, err := os.Stat(path.Join(testDir, "shows", "Sherlock.json"))
+	assert.NoError(t, err, "Expected the show to be saved.")
+
+	w = do(server, "POST", "/api/shows", `{"title":"Sherlock","id":335,"source":"tvmaze"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = do(server, "POST", "/api/shows/Sherlock/pause", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, s.Shows()["Sherlock"].Paused)
+
+	w = do(server, "POST", "/api/shows/Sherlock/settings", `{"anime":"true"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, s.Shows()["Sherlock"].Anime)
+
+	w = do(server, "POST", "/api/shows/Sherlock/settings", `{"anime":"maybe"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(server, "DELETE", "/api/shows/Sherlock", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Nil(t, s.Shows()["Sherlock"])
+
+	w = do(server, "GET", "/api/shows/Sherlock", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSnatches(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	server, s := testServer(t)
+
+	w := do(server, "GET", "/api/snatches", "")
+	assert.Equal(t, "[]\n", w.Body.String())
+
+	s.AppendHistory(store.HistoryEntry{Action: store.Snatched, Show: "Dead Set", Season: 1, Episode: 1, Torrent: "Dead.Set.S01E01"})
+	w = do(server, "GET", "/api/snatches", "")
+	assert.Contains(t, w.Body.String(), `"torrent":"Dead.Set.S01E01"`)
+}
+
+func TestFailedDownloadIsBlocked(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	server, s := testServer(t)
+	require.NoError(t, s.AppendHistory(store.HistoryEntry{
+		Action:   store.Snatched,
+		Show:     "Dead Set",
+		Season:   1,
+		Episode:  1,
+		Torrent:  "Dead.Set.S01E01",
+		InfoHash: "c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+	}))
+
+	w := do(server, "POST", "/api/failed", `{"info_hash": "C12FE1C06BBA254A9DC9F519B335AA7C1367A88A"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, s.IsBlocked("", "Dead.Set.S01E01"))
+	assert.True(t, s.Shows()["Dead Set"].Seasons[0].Episodes[0].Pending)
+
+	w = do(server, "POST", "/api/failed", `{"torrent": "Unknown"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"time"
+
+	"github.com/haarts/getme/store"
+)
+
+type showSummary struct {
+	Title           string    `json:"title"`
+	Source          string    `json:"source"`
+	ID              int       `json:"id"`
+	Ended           *bool     `json:"ended"`
+	Paused          bool      `json:"paused"`
+	Anime           bool      `json:"anime"`
+	Daily           bool      `json:"daily"`
+	Specials        bool      `json:"specials"`
+	AlternateTitles []string  `json:"alternate_titles"`
+	PendingEpisodes int       `json:"pending_episodes"`
+	RefreshedAt     time.Time `json:"refreshed_at"`
+}
+
+type showDetail struct {
+	showSummary
+	Seasons []seasonView `json:"seasons"`
+}
+
+type seasonView struct {
+	Season   int           `json:"season"`
+	Episodes []episodeView `json:"episodes"`
+}
+
+type episodeView struct {
+	Episode int       `json:"episode"`
+	Title   string    `json:"title"`
+	AirDate time.Time `json:"air_date"`
+	State   string    `json:"state"`
+	TriedAt time.Time `json:"tried_at"`
+}
+
+func summaryOf(show *store.Show) showSummary {
+	pending := 0
+	for _, season := range show.MonitoredSeasons() {
+		pending += len(season.PendingEpisodes())
+	}
+
+	return showSummary{
+		Title:           show.Title,
+		Source:          show.SourceName,
+		ID:              show.ID,
+		Ended:           show.Ended,
+		Paused:          show.Paused,
+		Anime:           show.Anime,
+		Daily:           show.IsDaily(),
+		Specials:        show.Specials,
+		AlternateTitles: show.Titles()[1:],
+		PendingEpisodes: pending,
+		RefreshedAt:     show.RefreshedAt,
+	}
+}
+
+func detailOf(show *store.Show, now time.Time) showDetail {
+	detail := showDetail{showSummary: summaryOf(show), Seasons: []seasonView{}}
+	for _, season := range show.Seasons {
+		view := seasonView{Season: season.Season, Episodes: []episodeView{}}
+		for _, episode := range season.Episodes {
+			view.Episodes = append(view.Episodes, episodeView{
+				Episode: episode.Episode,
+				Title:   episode.Title,
+				AirDate: episode.AirDate,
+				State:   episode.State(now),
+				TriedAt: episode.TriedAt,
+			})
+		}
+		detail.Seasons = append(detail.Seasons, view)
+	}
+	return detail
+}
+
+// Sorts shows alphabetically.
+type byTitle []showSummary
+
+func (a byTitle) Len() int           { return len(a) }
+func (a byTitle) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTitle) Less(i, j int) bool { return a[i].Title < a[j].Title }
@@ -2,19 +2,35 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/api"
 	"github.com/haarts/getme/config"
+	"github.com/haarts/getme/daemon"
+	"github.com/haarts/getme/hooks"
+	"github.com/haarts/getme/metrics"
+	"github.com/haarts/getme/notify"
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/sources"
 	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
 	"github.com/haarts/getme/ui"
+	"github.com/haarts/getme/web"
 )
 
-func handleShow(show *sources.Show) error {
+func handleShow(ctx context.Context, show *sources.Show) error {
 	store, err := store.Open(config.Config().StateDir)
 	if err != nil {
 		fmt.Println("We've failed to open the data store.")
@@ -24,10 +40,15 @@ func handleShow(show *sources.Show) error {
 		return err
 	}
 	defer store.Close()
+	torrents.UseStore(store)
 
 	// Fetch the seasons/episodes associated with the found show.
 	persistedShow := store.NewShow(show.Source, show.ID, show.URL, show.Title)
-	err = ui.Lookup(persistedShow)
+	persistedShow.Anime = show.Anime
+	if show.Daily {
+		persistedShow.Daily = &show.Daily
+	}
+	err = ui.Lookup(ctx, persistedShow)
 	if err != nil {
 		fmt.Println("We've encountered a problem looking up seasons for the show.")
 		log.WithFields(log.Fields{
@@ -54,14 +75,14 @@ func handleShow(show *sources.Show) error {
 	}
 
 	if !noDownload {
-		downloadTorrents(persistedShow)
+		downloadTorrents(ctx, store, persistedShow)
 	}
 
 	return nil
 }
 
-func downloadTorrents(show *store.Show) {
-	torrents, err := ui.SearchTorrents(show)
+func downloadTorrents(ctx context.Context, store *store.Store, show *store.Show) {
+	found, err := ui.SearchTorrents(ctx, show)
 	if err != nil {
 		// But that doesn't mean nothing worked...
 		fmt.Println("Something went wrong looking for your torrents. Continuing nonetheless")
@@ -69,13 +90,14 @@ func downloadTorrents(show *store.Show) {
 			"err": err,
 		}).Warn("Something went wrong looking for your torrents. Continuing nonetheless")
 	}
-	if len(torrents) == 0 {
+	if len(found) == 0 {
 		fmt.Println("Didn't find any torrents for show.")
 		log.WithFields(log.Fields{
 			"show": show.Title,
 		}).Info("Didn't find any torrents for show.")
 	}
-	err = ui.Download(torrents)
+	err = ui.Download(ctx, found)
+	torrents.RecordSnatches(ctx, store, show, found, time.Now())
 	if err != nil {
 		fmt.Println("Something went wrong downloading a torrent. Continuing nonetheless")
 		log.WithFields(log.Fields{
@@ -89,7 +111,191 @@ func loadConfig() {
 	ui.EnsureConfig()
 }
 
+func setupRequests() {
+	conf := config.Config()
+	request.Configure(conf.HTTPTimeout, conf.HTTPRetries, conf.HTTPInterval)
+
+	hosts := sources.Hosts()
+	for name, engineHosts := range torrents.Hosts() {
+		hosts[name] = engineHosts
+	}
+
+	// Search engines can share a host, like a torrent cache. Their proxies
+	// are set per host so they have to agree.
+	setBy := map[string]string{}
+	for name, setting := range conf.Proxies {
+		proxy, err := request.ParseProxy(setting)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"name": name,
+			}).Fatal("Invalid proxy.")
+		}
+
+		if name == "" {
+			request.SetGlobalProxy(proxy)
+			continue
+		}
+
+		if _, ok := hosts[name]; !ok {
+			log.WithFields(log.Fields{
+				"name": name,
+			}).Warn("Proxy configured for unknown source or search engine.")
+		}
+		for _, host := range hosts[name] {
+			if other, ok := setBy[host]; ok && conf.Proxies[other] != setting {
+				log.WithFields(log.Fields{
+					"host":  host,
+					"names": []string{other, name},
+				}).Fatal("Different proxies configured for a shared host.")
+			}
+			setBy[host] = name
+			request.SetProxy(host, proxy)
+		}
+	}
+}
+
+// notifyTargets are the names of the notification targets as used in the
+// config file.
+var notifyTargets = []string{"webhook", "email", "command"}
+
+func setupNotifications() {
+	conf := config.Config()
+
+	configured := map[string]notify.Target{}
+	if conf.WebhookURL != "" {
+		configured["webhook"] = notify.Webhook{URL: conf.WebhookURL}
+	}
+	if conf.SMTPServer != "" {
+		configured["email"] = notify.Email{
+			Server:   conf.SMTPServer,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+			From:     conf.SMTPFrom,
+			To:       conf.SMTPTo,
+		}
+	}
+	if conf.NotifyCommand != "" {
+		configured["command"] = notify.Command{Path: conf.NotifyCommand}
+	}
+
+	known := map[string]bool{}
+	for _, eventType := range notify.EventTypes {
+		known[eventType] = true
+	}
+	for eventType := range conf.NotifyTargets {
+		if !known[eventType] {
+			log.WithFields(log.Fields{
+				"event": eventType,
+			}).Warn("Notification configured for unknown event.")
+		}
+	}
+
+	for _, eventType := range notify.EventTypes {
+		names, ok := conf.NotifyTargets[eventType]
+		if !ok {
+			names = notifyTargets
+		}
+		for _, name := range names {
+			target, ok := configured[name]
+			if !ok {
+				if conf.NotifyTargets[eventType] != nil && name != "none" {
+					log.WithFields(log.Fields{
+						"event":  eventType,
+						"target": name,
+					}).Warn("Notification target isn't configured.")
+				}
+				continue
+			}
+			notify.Register(eventType, target)
+		}
+	}
+}
+
+func setupHooks() {
+	conf := config.Config()
+	if conf.HookTimeout != 0 {
+		hooks.Timeout = conf.HookTimeout
+	}
+
+	for hook, command := range conf.Hooks {
+		switch hook {
+		case hooks.PreSearch, hooks.PreSnatch, hooks.PostDownload:
+			hooks.Set(hook, command)
+		default:
+			log.WithFields(log.Fields{
+				"hook": hook,
+			}).Warn("Unknown hook configured.")
+		}
+	}
+}
+
+func setupScoring() {
+	conf := config.Config()
+
+	engines := torrents.Hosts()
+	for name := range conf.EngineTrust {
+		if _, ok := engines[name]; !ok {
+			log.WithFields(log.Fields{
+				"name": name,
+			}).Warn("Trust configured for unknown search engine.")
+		}
+	}
+
+	limits := map[string]torrents.SizeLimit{}
+	for key, limit := range conf.SizeLimits {
+		limits[key] = torrents.SizeLimit{Min: limit.Min, Max: limit.Max}
+	}
+
+	torrents.Prefer(torrents.Preferences{
+		Qualities:     conf.Qualities,
+		ReleaseGroups: conf.ReleaseGroups,
+		FansubGroups:  conf.FansubGroups,
+		Trust:         conf.EngineTrust,
+		SizeLimits:    limits,
+	})
+}
+
+func setupCache() {
+	conf := config.Config()
+	err := sources.EnableCache(conf.CacheDir, conf.CacheTTLs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("Failed to enable the cache. Continuing without it.")
+		return
+	}
+
+	if noCache {
+		sources.BypassCache()
+	}
+}
+
+func clearCachedResponses() {
+	err := sources.ClearCache(config.Config().CacheDir)
+	if err != nil {
+		fmt.Println("We've failed to clear the cache.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to clear the cache.")
+		return
+	}
+	fmt.Println("Cache cleared.")
+}
+
 var update bool
+var dryRun bool
+var doctor bool
+var runAsDaemon bool
+var history bool
+var badMedia string
+var setting string
+var historyShow string
+var historyEngine string
+var historySince string
+var historyUntil string
+var noCache bool
+var clearCache bool
 var mediaName string
 var logLevel int
 var noDownload bool
@@ -104,9 +310,21 @@ func init() {
 	const (
 		addUsage        = "The name of the show/movie to add."
 		updateUsage     = "Update the already added shows/movies and download pending torrents."
+		dryRunUsage     = "With -update, only show how the torrents found score. Nothing is downloaded or saved."
 		logLevelUsage   = "Set log level (0,1,2,3,4,5, higher is more logging)."
 		noDownloadUsage = "Find the show but don't download the torrents."
 		versionUsage    = "Show version"
+		doctorUsage     = "Report where the sources disagree on the added shows."
+		noCacheUsage    = "Ask the sources for fresh data instead of using the cache."
+		clearCacheUsage = "Remove every cached response of the sources."
+		daemonUsage     = "Keep running, refresh shows and search torrents as episodes air."
+		historyUsage    = "List what was decided about the torrents found and what was downloaded."
+		showUsage       = "Only list the history of this show, or the show to change with -set."
+		engineUsage     = "Only list the history of this search engine."
+		sinceUsage      = "Only list the history since this date (YYYY-MM-DD)."
+		untilUsage      = "Only list the history until this date (YYYY-MM-DD)."
+		badUsage        = "Block the torrent downloaded for an episode, like 'My show S01E02', and search for it again."
+		setUsage        = "Change a setting of the show given with -show, like anime=true."
 	)
 
 	flag.StringVar(&mediaName, "add", "", addUsage)
@@ -115,12 +333,32 @@ func init() {
 	flag.BoolVar(&update, "update", false, updateUsage)
 	flag.BoolVar(&update, "u", false, updateUsage+" (shorthand)")
 
+	flag.BoolVar(&dryRun, "dry-run", false, dryRunUsage)
+
 	flag.IntVar(&logLevel, "log-level", int(log.ErrorLevel), logLevelUsage)
 	flag.IntVar(&logLevel, "l", int(log.ErrorLevel), logLevelUsage+" (shorthand)")
 
 	flag.BoolVar(&noDownload, "no-download", false, noDownloadUsage)
 	flag.BoolVar(&noDownload, "n", false, noDownloadUsage+" (shorthand)")
 
+	flag.BoolVar(&doctor, "doctor", false, doctorUsage)
+
+	flag.BoolVar(&runAsDaemon, "daemon", false, daemonUsage)
+	flag.BoolVar(&runAsDaemon, "d", false, daemonUsage+" (shorthand)")
+
+	flag.BoolVar(&history, "history", false, historyUsage)
+	flag.StringVar(&historyShow, "show", "", showUsage)
+	flag.StringVar(&historyEngine, "engine", "", engineUsage)
+	flag.StringVar(&historySince, "since", "", sinceUsage)
+	flag.StringVar(&historyUntil, "until", "", untilUsage)
+
+	flag.StringVar(&badMedia, "bad", "", badUsage)
+
+	flag.StringVar(&setting, "set", "", setUsage)
+
+	flag.BoolVar(&noCache, "no-cache", false, noCacheUsage)
+	flag.BoolVar(&clearCache, "clear-cache", false, clearCacheUsage)
+
 	flag.BoolVar(&version, "version", false, versionUsage)
 	flag.BoolVar(&version, "v", false, versionUsage+" (shorthand)")
 
@@ -132,7 +370,220 @@ func init() {
 	// TODO add a yes flag (-y)
 }
 
-func updateMedia() {
+func updateMedia(ctx context.Context) {
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	defer store.Close()
+	torrents.UseStore(store)
+
+	ui.Update(ctx, store)
+
+	path := config.Config().MetricsTextfile
+	if path == "" {
+		return
+	}
+	observePendingEpisodes(store)
+	if err := metrics.WriteTextfile(path); err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": path,
+		}).Error("We've failed to write the metrics.")
+	}
+}
+
+var pendingEpisodes = metrics.NewGauge(
+	"getme_pending_episodes",
+	"Episodes which aired but haven't been downloaded, per show.",
+	"show")
+
+// observePendingEpisodes has the pending episodes of the shows in s counted
+// whenever the metrics are written.
+func observePendingEpisodes(s *store.Store) {
+	metrics.OnCollect(func() {
+		s.Lock()
+		defer s.Unlock()
+
+		now := time.Now()
+		pendingEpisodes.Reset()
+		for _, show := range s.Shows() {
+			var pending int
+			for _, season := range show.MonitoredSeasons() {
+				for _, episode := range season.Episodes {
+					if episode.State(now) == store.EpisodeWanted {
+						pending++
+					}
+				}
+			}
+			pendingEpisodes.Set(float64(pending), show.Title)
+		}
+	})
+}
+
+// dryRunMedia searches for the pending torrents without downloading them.
+// The store isn't closed, so nothing the searches change is saved.
+func dryRunMedia(ctx context.Context) {
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	torrents.UseStore(store)
+
+	ui.DryRun(ctx, store)
+}
+
+func diagnoseMedia(ctx context.Context) {
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	defer store.Close()
+
+	ui.Doctor(ctx, store)
+}
+
+// badPattern matches the argument of -bad, like 'My show S01E02' or
+// 'My show S01' for a whole season.
+var badPattern = regexp.MustCompile(`^(.+) [Ss](\d+)(?:[Ee](\d+))?$`)
+
+func markBad() {
+	matches := badPattern.FindStringSubmatch(badMedia)
+	if matches == nil {
+		fmt.Println("Please name the episode like so: ./getme -bad 'My show S01E02'.")
+		return
+	}
+	season, _ := strconv.Atoi(matches[2])
+	episode, _ := strconv.Atoi(matches[3])
+
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	defer store.Close()
+
+	show, ok := store.Shows()[matches[1]]
+	if !ok {
+		fmt.Printf("There is no show called %s.\n", matches[1])
+		return
+	}
+
+	blocked, err := store.MarkBad(show, season, episode, "marked bad", time.Now())
+	if err != nil {
+		fmt.Println("We've failed to mark the download as bad.")
+		log.WithFields(log.Fields{
+			"err":  err,
+			"show": show.Title,
+		}).Error("We've failed to mark the download as bad.")
+		return
+	}
+	fmt.Printf("Blocked %s, it will be searched for again.\n", blocked.Torrent)
+}
+
+func changeSetting() {
+	parts := strings.SplitN(setting, "=", 2)
+	if len(parts) != 2 || historyShow == "" {
+		fmt.Println("Please name the show and the setting like so: ./getme -show 'My show' -set anime=true.")
+		return
+	}
+
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	defer store.Close()
+
+	show, ok := store.Shows()[historyShow]
+	if !ok {
+		fmt.Printf("There is no show called %s.\n", historyShow)
+		return
+	}
+
+	if err := show.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])); err != nil {
+		fmt.Printf("We've failed to change the setting: %s.\n", err)
+		return
+	}
+	fmt.Printf("Changed %s of %s.\n", parts[0], show.Title)
+}
+
+// dateLayout is how dates are given on the command line.
+const dateLayout = "2006-01-02"
+
+func showHistory() {
+	store, err := store.Open(config.Config().StateDir)
+	if err != nil {
+		fmt.Println("We've failed to open the data store.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to open the data store.")
+		return
+	}
+	defer store.Close()
+
+	filter, err := historyFilter()
+	if err != nil {
+		fmt.Printf("Please give dates like %s.\n", dateLayout)
+		return
+	}
+
+	entries, err := store.History(filter)
+	if err != nil {
+		fmt.Println("We've failed to read the history.")
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("We've failed to read the history.")
+		return
+	}
+
+	ui.DisplayHistory(entries)
+}
+
+// historyFilter returns the filter given on the command line. The until date
+// is inclusive.
+func historyFilter() (store.HistoryFilter, error) {
+	filter := store.HistoryFilter{
+		Show:   historyShow,
+		Engine: historyEngine,
+	}
+
+	var err error
+	if historySince != "" {
+		filter.Since, err = time.ParseInLocation(dateLayout, historySince, time.Local)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if historyUntil != "" {
+		filter.Until, err = time.ParseInLocation(dateLayout, historyUntil, time.Local)
+		if err != nil {
+			return filter, err
+		}
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func runDaemon(ctx context.Context) {
 	store, err := store.Open(config.Config().StateDir)
 	if err != nil {
 		fmt.Println("We've failed to open the data store.")
@@ -143,7 +594,61 @@ func updateMedia() {
 	}
 	defer store.Close()
 
-	ui.Update(store)
+	torrents.UseStore(store)
+
+	conf := config.Config()
+	d := daemon.New(store, conf)
+
+	if conf.APIAddress != "" {
+		if conf.APIToken == "" {
+			fmt.Println("Please set api_token in the config file to serve the API.")
+			return
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/api/", api.New(store, d, conf.APIToken))
+		mux.Handle("/", web.New(store, conf.WatchDir, conf.APIToken))
+		go serve(ctx, mux, conf.APIAddress, "the API and web interface")
+	}
+
+	if conf.MetricsAddress != "" {
+		observePendingEpisodes(store)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go serve(ctx, mux, conf.MetricsAddress, "the metrics")
+	}
+
+	fmt.Println("Running as daemon, stop with Ctrl-C.")
+	d.Run(ctx)
+}
+
+// shutdownTimeout is how long requests in progress get to finish when the
+// daemon stops.
+const shutdownTimeout = 5 * time.Second
+
+// serve serves what, like the API, until the context is cancelled.
+func serve(ctx context.Context, handler http.Handler, address, what string) {
+	server := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithFields(log.Fields{
+		"address": address,
+	}).Info("Serving " + what + ".")
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Println("We've failed to serve " + what + ".")
+		log.WithFields(log.Fields{
+			"err":     err,
+			"address": address,
+		}).Error("We've failed to serve " + what + ".")
+	}
 }
 
 func allEmpty(results []sources.SearchResult) bool {
@@ -156,13 +661,13 @@ func allEmpty(results []sources.SearchResult) bool {
 }
 
 // TODO shouldn't this be in the ui package?
-func addMedia() {
+func addMedia(ctx context.Context) {
 	if mediaName == "" {
 		fmt.Println("Please specify a name to add. Like so: ./getme -a 'My show'.")
 		return
 	}
 
-	matches := ui.Search(mediaName)
+	matches := ui.Search(ctx, mediaName)
 	if allEmpty(matches) {
 		fmt.Println("We haven't found what you were looking for.")
 		return
@@ -181,7 +686,7 @@ func addMedia() {
 
 	switch m := (match).(type) {
 	case *sources.Show:
-		err := handleShow(m)
+		err := handleShow(ctx, m)
 		if err != nil {
 			return
 		}
@@ -209,9 +714,47 @@ func main() {
 
 	config.SetLoggerTo(logLevel)
 
-	if update {
-		updateMedia()
+	if clearCache {
+		clearCachedResponses()
+		return
+	}
+
+	setupRequests()
+	setupCache()
+	setupNotifications()
+	setupHooks()
+	setupScoring()
+
+	ctx, stop := interruptible()
+	defer stop()
+
+	if update && dryRun {
+		dryRunMedia(ctx)
+	} else if update {
+		updateMedia(ctx)
+	} else if runAsDaemon {
+		runDaemon(ctx)
+	} else if doctor {
+		diagnoseMedia(ctx)
+	} else if history {
+		showHistory()
+	} else if badMedia != "" {
+		markBad()
+	} else if setting != "" {
+		changeSetting()
 	} else {
-		addMedia()
+		addMedia(ctx)
 	}
 }
+
+// interruptible returns a context which is cancelled on Ctrl-C or SIGTERM.
+// Cancelling stops the requests in flight and lets the store be saved. A
+// second Ctrl-C kills GetMe straight away.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
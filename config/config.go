@@ -8,7 +8,10 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -17,6 +20,72 @@ import (
 // the state should be stored. And WHERE the log files should be stored.
 type Conf struct {
 	WatchDir, StateDir, LogDir string
+	// CacheDir holds the responses of sources, see CacheTTLs.
+	CacheDir string
+	// CacheTTLs holds, per source, how long a response is used without
+	// asking the source again. Configured with '<source>_cache_ttl = 12h'.
+	CacheTTLs map[string]time.Duration
+	// HTTPTimeout, HTTPRetries and HTTPInterval tune the requests to sources
+	// and search engines. See the request package. Zero means the default.
+	HTTPTimeout  time.Duration
+	HTTPRetries  int
+	HTTPInterval time.Duration
+	// Proxies holds, per source or search engine name, the proxy its
+	// requests go through. The empty name holds the proxy for everything
+	// else. Configured with 'proxy = ...' and '<name>_proxy = ...'.
+	Proxies map[string]string
+	// RefreshInterval is how often the daemon refreshes a show from its
+	// sources. Shows can override it, see store.Show.
+	RefreshInterval time.Duration
+	// SearchDelay is how long after an episode aired the daemon first
+	// searches for it. Each failed search doubles the wait, up to
+	// MaxSearchBackoff.
+	SearchDelay      time.Duration
+	MaxSearchBackoff time.Duration
+	// APIAddress is where the daemon serves the API, like ':8080'. Empty
+	// disables the API. Requests must carry APIToken.
+	APIAddress string
+	APIToken   string
+	// MetricsAddress is where the daemon serves the metrics, like ':9090'.
+	// Empty disables serving them.
+	MetricsAddress string
+	// MetricsTextfile is where the metrics are written after an update, for
+	// the node exporter's textfile collector. Empty disables writing them.
+	MetricsTextfile string
+	// WebhookURL, SMTP* and NotifyCommand configure the notification
+	// targets, see the notify package.
+	WebhookURL    string
+	SMTPServer    string
+	SMTPUsername  string
+	SMTPPassword  string
+	SMTPFrom      string
+	SMTPTo        []string
+	NotifyCommand string
+	// NotifyTargets holds, per event type, the names of the targets
+	// notified. Configured with 'notify_<event> = webhook, email'. Event
+	// types which aren't configured go to every target.
+	NotifyTargets map[string][]string
+	// Hooks holds, per hook, the command to run. Configured with
+	// '<hook>_hook = /path/to/command', see the hooks package.
+	Hooks       map[string]string
+	HookTimeout time.Duration
+	// Qualities, ReleaseGroups, FansubGroups and EngineTrust tune the
+	// scoring of torrents, see torrents.Preferences. EngineTrust is
+	// configured with '<engine>_trust = 0.5'.
+	Qualities     []string
+	ReleaseGroups []string
+	FansubGroups  []string
+	EngineTrust   map[string]float64
+	// SizeLimits holds the sizes torrents must have, keyed by 'episode',
+	// 'season' (per episode in the pack) or 'movie', optionally followed by
+	// a quality like in 'episode_1080p'. Configured with
+	// 'episode_size = 100MB-2GB'.
+	SizeLimits map[string]SizeRange
+}
+
+// SizeRange bounds a size in bytes. Zero means unbounded.
+type SizeRange struct {
+	Min, Max int64
 }
 
 // CheckConfig see if the config file is present.
@@ -58,23 +127,100 @@ func Config() *Conf {
 	}
 	defer file.Close()
 
-	conf := Conf{}
+	conf := Conf{
+		CacheTTLs:        make(map[string]time.Duration),
+		Proxies:          make(map[string]string),
+		NotifyTargets:    make(map[string][]string),
+		Hooks:            make(map[string]string),
+		EngineTrust:      make(map[string]float64),
+		SizeLimits:       make(map[string]SizeRange),
+		RefreshInterval:  24 * time.Hour,
+		SearchDelay:      time.Hour,
+		MaxSearchBackoff: 7 * 24 * time.Hour,
+	}
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		text := scanner.Text()
-		parts := strings.Split(text, "=")
+		parts := strings.SplitN(text, "=", 2)
 		for i := range parts {
 			parts[i] = strings.Trim(parts[i], " ")
 		}
-		switch parts[0] {
-		case "watch_dir":
+		switch {
+		case parts[0] == "watch_dir":
 			conf.WatchDir = parts[1]
+		case parts[0] == "http_timeout":
+			conf.HTTPTimeout, err = time.ParseDuration(parts[1])
+		case parts[0] == "http_retries":
+			conf.HTTPRetries, err = strconv.Atoi(parts[1])
+		case parts[0] == "http_interval":
+			conf.HTTPInterval, err = time.ParseDuration(parts[1])
+		case parts[0] == "refresh_interval":
+			conf.RefreshInterval, err = parsePositiveDuration(parts[1])
+		case parts[0] == "search_delay":
+			conf.SearchDelay, err = parsePositiveDuration(parts[1])
+		case parts[0] == "max_search_backoff":
+			conf.MaxSearchBackoff, err = time.ParseDuration(parts[1])
+		case parts[0] == "api_address":
+			conf.APIAddress = parts[1]
+		case parts[0] == "api_token":
+			conf.APIToken = parts[1]
+		case parts[0] == "metrics_address":
+			conf.MetricsAddress = parts[1]
+		case parts[0] == "metrics_textfile":
+			conf.MetricsTextfile = parts[1]
+		case parts[0] == "webhook_url":
+			conf.WebhookURL = parts[1]
+		case parts[0] == "smtp_server":
+			conf.SMTPServer = parts[1]
+		case parts[0] == "smtp_username":
+			conf.SMTPUsername = parts[1]
+		case parts[0] == "smtp_password":
+			conf.SMTPPassword = parts[1]
+		case parts[0] == "smtp_from":
+			conf.SMTPFrom = parts[1]
+		case parts[0] == "smtp_to":
+			conf.SMTPTo = splitList(parts[1])
+		case parts[0] == "notify_command":
+			conf.NotifyCommand = parts[1]
+		case strings.HasPrefix(parts[0], "notify_"):
+			conf.NotifyTargets[strings.TrimPrefix(parts[0], "notify_")] = splitList(parts[1])
+		case parts[0] == "hook_timeout":
+			conf.HookTimeout, err = time.ParseDuration(parts[1])
+		case strings.HasSuffix(parts[0], "_hook"):
+			conf.Hooks[strings.TrimSuffix(parts[0], "_hook")] = parts[1]
+		case parts[0] == "quality":
+			conf.Qualities = splitList(parts[1])
+		case parts[0] == "release_groups":
+			conf.ReleaseGroups = splitList(parts[1])
+		case parts[0] == "fansub_groups":
+			conf.FansubGroups = splitList(parts[1])
+		case sizeKey.MatchString(parts[0]):
+			var limit SizeRange
+			limit, err = parseSizeRange(parts[1])
+			conf.SizeLimits[strings.Replace(parts[0], "_size", "", 1)] = limit
+		case strings.HasSuffix(parts[0], "_trust"):
+			var trust float64
+			trust, err = strconv.ParseFloat(parts[1], 64)
+			conf.EngineTrust[strings.TrimSuffix(parts[0], "_trust")] = trust
+		case parts[0] == "proxy":
+			conf.Proxies[""] = parts[1]
+		case strings.HasSuffix(parts[0], "_proxy"):
+			conf.Proxies[strings.TrimSuffix(parts[0], "_proxy")] = parts[1]
+		case strings.HasSuffix(parts[0], "_cache_ttl"):
+			var ttl time.Duration
+			ttl, err = time.ParseDuration(parts[1])
+			conf.CacheTTLs[strings.TrimSuffix(parts[0], "_cache_ttl")] = ttl
 		default:
 			fmt.Println("Found an unknown key in config.ini: " + parts[0])
 			failed = true
 			return nil
 		}
+		if err != nil {
+			fmt.Println("Found an invalid value in config.ini for " + parts[0])
+			failed = true
+			return nil
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -102,6 +248,7 @@ func Config() *Conf {
 
 	// setup storage/state dirs
 	conf.StateDir = stateDir()
+	conf.CacheDir = path.Join(conf.StateDir, "cache")
 	err = ensureStateDir(conf.StateDir)
 	if err != nil {
 		fmt.Println("Something went wrong creating the state directories:", err) //TODO replace with log.Fatal()
@@ -113,6 +260,81 @@ func Config() *Conf {
 	return memoizedConfig
 }
 
+// splitList splits a comma separated value.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parsePositiveDuration parses durations like time.ParseDuration but rejects
+// those which aren't positive. The daemon would loop on them.
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %s isn't positive", value)
+	}
+	return d, nil
+}
+
+var sizeKey = regexp.MustCompile(`^(episode|season|movie)_size(_.+)?$`)
+
+// parseSizeRange parses ranges like '100MB-2GB', '-2GB' or '1.5GiB-'.
+func parseSizeRange(value string) (SizeRange, error) {
+	bounds := strings.SplitN(value, "-", 2)
+	if len(bounds) != 2 {
+		return SizeRange{}, fmt.Errorf("size range %s misses a '-'", value)
+	}
+
+	var limit SizeRange
+	var err error
+	if limit.Min, err = parseSize(bounds[0]); err != nil {
+		return limit, err
+	}
+	if limit.Max, err = parseSize(bounds[1]); err != nil {
+		return limit, err
+	}
+	if limit.Max != 0 && limit.Min > limit.Max {
+		return limit, fmt.Errorf("size range %s is empty", value)
+	}
+	return limit, nil
+}
+
+var sizeUnits = map[string]float64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+var sizePattern = regexp.MustCompile(`^([0-9.]+)\s*([KMGT]?)I?B?$`)
+
+// parseSize parses sizes like '700MB', '1.5G' or '4GiB', in multiples of
+// 1024. Empty is zero.
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	matches := sizePattern.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	number, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(number * sizeUnits[matches[2]]), nil
+}
+
 func ensureWatchDir(watchDir string) error {
 	return ensureDirs([]string{watchDir})
 }
@@ -126,6 +348,7 @@ func ensureStateDir(stateDir string) error {
 		stateDir,
 		path.Join(stateDir, "shows"),
 		path.Join(stateDir, "movies"),
+		path.Join(stateDir, "cache"),
 	}
 
 	return ensureDirs(dirs)
@@ -180,5 +403,6 @@ func WriteDefaultConfig() {
 
 func defaultConfigData(homeDir string) []byte {
 	watchDir := fmt.Sprintln("watch_dir = /tmp/torrents")
-	return []byte(watchDir)
+	cacheTTL := fmt.Sprintln("tvmaze_cache_ttl = 12h")
+	return []byte(watchDir + cacheTTL)
 }
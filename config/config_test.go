@@ -0,0 +1,35 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePositiveDuration(t *testing.T) {
+	d, err := parsePositiveDuration("2h")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, d)
+
+	for _, invalid := range []string{"0", "-1h", "soon"} {
+		_, err = parsePositiveDuration(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	limit, err := parseSizeRange("100MB-1.5GiB")
+	require.NoError(t, err)
+	assert.Equal(t, SizeRange{Min: 100 << 20, Max: 3 << 29}, limit)
+
+	limit, err = parseSizeRange("- 4g")
+	require.NoError(t, err)
+	assert.Equal(t, SizeRange{Max: 4 << 30}, limit)
+
+	for _, invalid := range []string{"4GB", "1GB-100MB", "lots-more"} {
+		_, err = parseSizeRange(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,385 @@
+// Package daemon runs GetMe as a long running process. Shows are refreshed
+// from their sources at an interval and torrents are searched for around the
+// time episodes air.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/config"
+	"github.com/haarts/getme/notify"
+	"github.com/haarts/getme/sources"
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+// maxSleep bounds how long the daemon sleeps between two plans.
+const maxSleep = time.Hour
+
+// refreshRetry is how long the daemon waits before refreshing a show again
+// after refreshing it failed.
+const refreshRetry = time.Hour
+
+// minSearchWait keeps a misconfigured search delay from searching in a loop.
+const minSearchWait = time.Minute
+
+// failingSearches is the number of searches which didn't find an episode
+// after which the search_failing notification is sent.
+const failingSearches = 5
+
+type jobKind int
+
+const (
+	refreshJob jobKind = iota
+	searchJob
+)
+
+func (k jobKind) String() string {
+	if k == refreshJob {
+		return "refresh"
+	}
+	return "search"
+}
+
+type job struct {
+	show *store.Show
+	kind jobKind
+	due  time.Time
+}
+
+// Daemon schedules the refreshes and searches of the shows in a store.
+type Daemon struct {
+	// RefreshInterval, SearchDelay and MaxSearchBackoff, see config.Conf.
+	RefreshInterval  time.Duration
+	SearchDelay      time.Duration
+	MaxSearchBackoff time.Duration
+	// WatchDir is where found torrents are written to.
+	WatchDir string
+
+	store   *store.Store
+	retryAt map[string]time.Time
+	wake    chan struct{}
+	refresh func(context.Context, *store.Show) error
+	search  func(context.Context, *store.Show) error
+	now     func() time.Time
+}
+
+// New returns a daemon for the shows in s configured with conf.
+func New(s *store.Store, conf *config.Conf) *Daemon {
+	d := &Daemon{
+		RefreshInterval:  conf.RefreshInterval,
+		SearchDelay:      conf.SearchDelay,
+		MaxSearchBackoff: conf.MaxSearchBackoff,
+		WatchDir:         conf.WatchDir,
+		store:            s,
+		retryAt:          make(map[string]time.Time),
+		wake:             make(chan struct{}, 1),
+		now:              time.Now,
+	}
+	d.refresh = refreshShow
+	d.search = d.searchShow
+	return d
+}
+
+// Wake makes the daemon plan again, for example after a show was added.
+func (d *Daemon) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshNow makes a show due for a refresh and wakes the daemon. The store
+// must be locked by the caller.
+func (d *Daemon) RefreshNow(show *store.Show) {
+	show.RefreshedAt = time.Time{}
+	delete(d.retryAt, show.Title)
+	d.Wake()
+}
+
+// Run executes jobs as they become due until the context is cancelled. A job
+// in progress is cancelled too. Every show is saved after each job, progress
+// is never lost when the daemon stops.
+//
+// The store is locked while planning and around a job, to copy the show
+// before and to save it after. A job itself works on the copy, the API stays
+// responsive while it searches.
+func (d *Daemon) Run(ctx context.Context) {
+	log.Info("Daemon started.")
+	for {
+		wait := maxSleep
+		executed := false
+
+		d.store.Lock()
+		jobs := d.plan()
+		d.store.Unlock()
+
+		for _, j := range jobs {
+			if ctx.Err() != nil {
+				break
+			}
+			if j.due.After(d.now()) {
+				if until := j.due.Sub(d.now()); until < wait {
+					wait = until
+				}
+				break
+			}
+			d.execute(ctx, j)
+			executed = true
+		}
+
+		if executed {
+			// Jobs change what is due next, plan again straight away.
+			wait = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info("Daemon stopped.")
+			return
+		case <-d.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// plan returns the jobs for every show, the earliest due first.
+func (d *Daemon) plan() []job {
+	var jobs []job
+	for _, show := range d.store.Shows() {
+		if show.Paused {
+			continue
+		}
+
+		jobs = append(jobs, job{show: show, kind: refreshJob, due: d.nextRefresh(show)})
+
+		if due, ok := d.nextSearchOf(show); ok {
+			jobs = append(jobs, job{show: show, kind: searchJob, due: due})
+		}
+	}
+	sort.Stable(byDue(jobs))
+	return jobs
+}
+
+// isCurrent tells whether the show of a job is still in the store, it might
+// have been removed or replaced. The store is locked by the caller.
+func (d *Daemon) isCurrent(j job) bool {
+	show, ok := d.store.Shows()[j.show.Title]
+	return ok && show == j.show
+}
+
+func (d *Daemon) execute(ctx context.Context, j job) {
+	d.store.Lock()
+	// The show might have been removed or paused since planning.
+	if !d.isCurrent(j) || j.show.Paused {
+		d.store.Unlock()
+		return
+	}
+	base := j.show.Copy()
+	show := j.show.Copy()
+	d.store.Unlock()
+
+	logEntry := log.WithFields(log.Fields{
+		"show": show.Title,
+		"job":  j.kind.String(),
+	})
+	logEntry.Debug("Executing job.")
+
+	var err error
+	switch j.kind {
+	case refreshJob:
+		err = d.refresh(ctx, show)
+	case searchJob:
+		searched := d.dueEpisodes(show)
+		waiting := holdBack(show, searched)
+		err = d.search(ctx, show)
+		for _, episode := range waiting {
+			episode.Pending = true
+		}
+		// An interrupted search doesn't count as a try.
+		if ctx.Err() == nil {
+			for _, episode := range searched {
+				episode.Tried(d.now())
+				if episode.Backoff == failingSearches {
+					notify.Send(ctx, notify.Event{
+						Type:    notify.SearchFailing,
+						Show:    show.Title,
+						Season:  episode.Season(),
+						Episode: episode.Episode,
+						Message: fmt.Sprintf("No torrent found after %d searches.", failingSearches),
+					})
+				}
+			}
+		}
+	}
+	if err != nil {
+		logEntry.WithFields(log.Fields{
+			"err": err,
+		}).Error("Job failed.")
+	}
+
+	d.store.Lock()
+	defer d.store.Unlock()
+
+	if !d.isCurrent(j) {
+		logEntry.Info("Show was removed during the job, dropping its results.")
+		return
+	}
+	j.show.Merge(base, show)
+	if j.kind == refreshJob {
+		if err == nil {
+			j.show.RefreshedAt = d.now()
+			delete(d.retryAt, j.show.Title)
+		} else {
+			d.retryAt[j.show.Title] = d.now().Add(refreshRetry)
+		}
+	}
+
+	if err := d.store.Save(j.show); err != nil {
+		logEntry.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to save show.")
+	}
+}
+
+// nextRefresh returns when a show should be refreshed from its sources.
+func (d *Daemon) nextRefresh(show *store.Show) time.Time {
+	if retry, ok := d.retryAt[show.Title]; ok {
+		return retry
+	}
+
+	interval := d.RefreshInterval
+	if show.RefreshInterval != "" {
+		i, err := time.ParseDuration(show.RefreshInterval)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"show":             show.Title,
+				"refresh_interval": show.RefreshInterval,
+			}).Warn("Ignoring invalid refresh interval.")
+		} else {
+			interval = i
+		}
+	}
+	return show.RefreshedAt.Add(interval)
+}
+
+// nextSearchOf returns when torrents should be searched for the pending
+// episodes of a show. The second return value is false when nothing is
+// pending.
+func (d *Daemon) nextSearchOf(show *store.Show) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, episode := range pendingEpisodes(show) {
+		due := d.nextSearch(episode)
+		if !found || due.Before(next) {
+			next = due
+			found = true
+		}
+	}
+	return next, found
+}
+
+// nextSearch returns when an episode should be searched for. That is
+// SearchDelay after it aired and, when that didn't find it, with an
+// exponential back off capped at MaxSearchBackoff.
+func (d *Daemon) nextSearch(episode *store.Episode) time.Time {
+	if episode.TriedAt.IsZero() {
+		if episode.AirDate.IsZero() {
+			return d.now()
+		}
+		return episode.AirDate.Add(d.SearchDelay)
+	}
+
+	wait := d.SearchDelay
+	for i := 0; i < episode.Backoff && wait < d.MaxSearchBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxSearchBackoff {
+		wait = d.MaxSearchBackoff
+	}
+	if wait < minSearchWait {
+		wait = minSearchWait
+	}
+	return episode.TriedAt.Add(wait)
+}
+
+// dueEpisodes returns the pending episodes which are due for a search.
+func (d *Daemon) dueEpisodes(show *store.Show) []*store.Episode {
+	var due []*store.Episode
+	for _, episode := range pendingEpisodes(show) {
+		if !d.nextSearch(episode).After(d.now()) {
+			due = append(due, episode)
+		}
+	}
+	return due
+}
+
+// holdBack makes the pending episodes of a show which aren't due not pending,
+// so a search leaves them alone, and returns them.
+func holdBack(show *store.Show, due []*store.Episode) []*store.Episode {
+	isDue := map[*store.Episode]bool{}
+	for _, episode := range due {
+		isDue[episode] = true
+	}
+
+	var waiting []*store.Episode
+	for _, episode := range pendingEpisodes(show) {
+		if !isDue[episode] {
+			episode.Pending = false
+			waiting = append(waiting, episode)
+		}
+	}
+	return waiting
+}
+
+// pendingEpisodes returns every pending episode of the monitored seasons of a
+// show, including those in pending seasons.
+func pendingEpisodes(show *store.Show) []*store.Episode {
+	var episodes []*store.Episode
+	for _, season := range show.MonitoredSeasons() {
+		episodes = append(episodes, season.PendingEpisodes()...)
+	}
+	return episodes
+}
+
+func refreshShow(ctx context.Context, show *store.Show) error {
+	if !sources.IsAvailable(show.SourceName) {
+		if err := sources.Relink(ctx, show, nil); err != nil {
+			return err
+		}
+	}
+
+	if show.ExternalIDs == nil {
+		sources.Link(ctx, show)
+	}
+	return sources.UpdateSeasonsAndEpisodes(ctx, show)
+}
+
+func (d *Daemon) searchShow(ctx context.Context, show *store.Show) error {
+	found, err := torrents.Search(ctx, show)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	err = torrents.Download(ctx, found, d.WatchDir)
+	torrents.RecordSnatches(ctx, d.store, show, found, d.now())
+	return err
+}
+
+// Sorts the earliest due job on top.
+type byDue []job
+
+func (a byDue) Len() int           { return len(a) }
+func (a byDue) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDue) Less(i, j int) bool { return a[i].due.Before(a[j].due) }
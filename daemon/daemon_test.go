@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/config"
+	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testDaemon(s *store.Store) *Daemon {
+	d := New(s, &config.Conf{
+		RefreshInterval:  24 * time.Hour,
+		SearchDelay:      time.Hour,
+		MaxSearchBackoff: 8 * time.Hour,
+	})
+	d.now = func() time.Time { return now }
+	return d
+}
+
+func TestNextSearch(t *testing.T) {
+	d := testDaemon(nil)
+
+	airDate := now.Add(-30 * time.Minute)
+	episode := &store.Episode{Pending: true, AirDate: airDate}
+	assert.Equal(t, airDate.Add(time.Hour), d.nextSearch(episode))
+
+	episode.Tried(now)
+	assert.Equal(t, 1, episode.Backoff)
+	assert.Equal(t, now.Add(2*time.Hour), d.nextSearch(episode))
+
+	episode.Backoff = 10
+	assert.Equal(t, now.Add(8*time.Hour), d.nextSearch(episode), "back off is capped")
+
+	assert.Equal(t, now, d.nextSearch(&store.Episode{Pending: true}), "unknown air dates are due")
+}
+
+func TestNextRefresh(t *testing.T) {
+	d := testDaemon(nil)
+
+	show := &store.Show{Title: "foo", RefreshedAt: now}
+	assert.Equal(t, now.Add(24*time.Hour), d.nextRefresh(show))
+
+	show.RefreshInterval = "72h"
+	assert.Equal(t, now.Add(72*time.Hour), d.nextRefresh(show))
+
+	show.RefreshInterval = "bogus"
+	assert.Equal(t, now.Add(24*time.Hour), d.nextRefresh(show))
+}
+
+func TestRunSavesAfterEveryJob(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, err := store.Open(testDir)
+	require.NoError(t, err)
+	aired := &store.Episode{Episode: 1, Pending: true, AirDate: now.Add(-2 * time.Hour)}
+	upcoming := &store.Episode{Episode: 2, Pending: true, AirDate: now.Add(time.Hour)}
+	show := &store.Show{
+		Title:       "my show",
+		RefreshedAt: now,
+		Seasons:     []*store.Season{{Season: 1, Episodes: []*store.Episode{aired, upcoming}}},
+	}
+	s.CreateShow(show)
+
+	// Nothing else is due for hours, the daemon sleeps until it is stopped.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	d := testDaemon(s)
+	d.refresh = func(context.Context, *store.Show) error {
+		t.Error("Expected the show not to be refreshed yet.")
+		return nil
+	}
+	var searches int
+	d.search = func(_ context.Context, copied *store.Show) error {
+		searches++
+		copied.Seasons[0].Episodes[0].Done()
+
+		locked := make(chan struct{})
+		go func() {
+			s.Lock()
+			s.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(50 * time.Millisecond):
+			t.Error("Expected the store not to be locked while searching.")
+		}
+		return nil
+	}
+
+	d.Run(ctx)
+
+	assert.Equal(t, 1, searches)
+	aired, upcoming = show.Seasons[0].Episodes[0], show.Seasons[0].Episodes[1]
+	assert.False(t, aired.Pending, "the results of the job are kept")
+	assert.Equal(t, now, aired.TriedAt)
+	assert.True(t, upcoming.TriedAt.IsZero(), "upcoming episodes aren't tried yet")
+	_, err = os.Stat(path.Join(testDir, "shows", "my_show.json"))
+	assert.NoError(t, err, "Expected the show to be saved after the job.")
+}
+
+func TestEditsDuringAJobAreKept(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, err := store.Open(testDir)
+	require.NoError(t, err)
+	show := &store.Show{
+		Title:       "my show",
+		RefreshedAt: now,
+		Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+			{Episode: 1, Pending: true, AirDate: now.Add(-2 * time.Hour)},
+			{Episode: 2, Pending: true, AirDate: now.Add(-2 * time.Hour)},
+		}}},
+	}
+	s.CreateShow(show)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	d := testDaemon(s)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	d.search = func(_ context.Context, copied *store.Show) error {
+		close(started)
+		<-release
+		copied.Seasons[0].Episodes[0].Done()
+		return nil
+	}
+
+	go func() {
+		<-started
+		// Marked as downloaded in the web interface while searching.
+		s.Lock()
+		show.Seasons[0].Episodes[1].Done()
+		s.Unlock()
+		close(release)
+	}()
+	d.Run(ctx)
+
+	episodes := show.Seasons[0].Episodes
+	assert.False(t, episodes[0].Pending, "found by the search")
+	assert.False(t, episodes[1].Pending, "marked during the search")
+	assert.Equal(t, now, episodes[1].TriedAt)
+}
+
+func TestOnlyDueEpisodesAreSearched(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, err := store.Open(testDir)
+	require.NoError(t, err)
+	show := &store.Show{
+		Title:       "my show",
+		RefreshedAt: now,
+		Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+			{Episode: 1, Pending: true, AirDate: now.Add(-2 * time.Hour)},
+			{Episode: 2, Pending: true, AirDate: now.Add(-48 * time.Hour), TriedAt: now.Add(-time.Hour), Backoff: 3},
+		}}},
+	}
+	s.CreateShow(show)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	d := testDaemon(s)
+	var searched []int
+	d.search = func(_ context.Context, copied *store.Show) error {
+		for _, episode := range copied.PendingEpisodes() {
+			searched = append(searched, episode.Episode)
+		}
+		return nil
+	}
+	d.Run(ctx)
+
+	assert.Equal(t, []int{1}, searched, "episode 2 backs off")
+	backingOff := show.Seasons[0].Episodes[1]
+	assert.True(t, backingOff.Pending)
+	assert.Equal(t, 3, backingOff.Backoff)
+}
@@ -0,0 +1,128 @@
+// Package hooks runs user configured commands around searching for and
+// downloading torrents. The commands get the details as GETME_* environment
+// variables and as JSON on stdin.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The moments a hook can run.
+const (
+	// PreSearch runs before the search engines are asked for a season or an
+	// episode. A failing command skips the search.
+	PreSearch = "pre_search"
+	// PreSnatch runs after a torrent is chosen and before it is downloaded.
+	// A failing command vetoes the torrent, the next best one is tried.
+	PreSnatch = "pre_snatch"
+	// PostDownload runs after a torrent was written to the watch directory.
+	PostDownload = "post_download"
+)
+
+// Details describe what a hook runs for.
+type Details struct {
+	Hook    string `json:"hook"`
+	Show    string `json:"show"`
+	Season  int    `json:"season"`
+	Episode int    `json:"episode,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Torrent string `json:"torrent,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Seeds   int    `json:"seeds,omitempty"`
+	// File is where the torrent was written to.
+	File string `json:"file,omitempty"`
+}
+
+func (d Details) env() []string {
+	return []string{
+		"GETME_HOOK=" + d.Hook,
+		"GETME_SHOW=" + d.Show,
+		"GETME_SEASON=" + strconv.Itoa(d.Season),
+		"GETME_EPISODE=" + strconv.Itoa(d.Episode),
+		"GETME_QUERY=" + d.Query,
+		"GETME_TORRENT=" + d.Torrent,
+		"GETME_URL=" + d.URL,
+		"GETME_SEEDS=" + strconv.Itoa(d.Seeds),
+		"GETME_FILE=" + d.File,
+	}
+}
+
+// Timeout is the time a command gets before it is killed. A killed command
+// failed.
+var Timeout = 30 * time.Second
+
+var commands = struct {
+	sync.RWMutex
+	byHook map[string]string
+}{
+	byHook: make(map[string]string),
+}
+
+// Set configures the command run for a hook.
+func Set(hook, command string) {
+	commands.Lock()
+	defer commands.Unlock()
+	commands.byHook[hook] = command
+}
+
+// Reset removes every configured command.
+func Reset() {
+	commands.Lock()
+	defer commands.Unlock()
+	commands.byHook = make(map[string]string)
+}
+
+// Run runs the command configured for details.Hook. An error is returned
+// when the command failed, which means a veto for PreSearch and PreSnatch.
+// Nothing happens when no command is configured.
+func Run(ctx context.Context, details Details) error {
+	commands.RLock()
+	command, ok := commands.byHook[details.Hook]
+	commands.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), details.env()...)
+
+	output, err := cmd.CombinedOutput()
+	logEntry := log.WithFields(log.Fields{
+		"hook":    details.Hook,
+		"command": command,
+		"show":    details.Show,
+		"output":  string(bytes.TrimSpace(output)),
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		logEntry.Warn("Hook timed out.")
+		return fmt.Errorf("hook %s timed out after %s", details.Hook, Timeout)
+	}
+	if err != nil {
+		logEntry.WithFields(log.Fields{
+			"err": err,
+		}).Info("Hook failed.")
+		return fmt.Errorf("hook %s failed: %s", details.Hook, err)
+	}
+
+	logEntry.Debug("Hook succeeded.")
+	return nil
+}
@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// script writes an executable shell script and returns its path.
+func script(t *testing.T, dir, body string) string {
+	p := path.Join(dir, "hook.sh")
+	require.NoError(t, ioutil.WriteFile(p, []byte("#!/bin/sh\n"+body+"\n"), 0755))
+	return p
+}
+
+func TestNoCommandConfigured(t *testing.T) {
+	assert.NoError(t, Run(context.Background(), Details{Hook: PreSnatch}))
+}
+
+func TestDetailsArePassed(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	defer Reset()
+
+	out := path.Join(dir, "out")
+	Set(PostDownload, script(t, dir, `echo "$GETME_HOOK $GETME_SHOW $GETME_SEASON $GETME_EPISODE" > `+out+`; cat >> `+out))
+
+	err := Run(context.Background(), Details{Hook: PostDownload, Show: "Dead Set", Season: 1, Episode: 2, Torrent: "Dead.Set.S01E02"})
+	require.NoError(t, err)
+
+	written, _ := ioutil.ReadFile(out)
+	assert.True(t, strings.HasPrefix(string(written), "post_download Dead Set 1 2\n"))
+	assert.Contains(t, string(written), `"torrent":"Dead.Set.S01E02"`)
+}
+
+func TestVeto(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	defer Reset()
+
+	Set(PreSnatch, script(t, dir, `[ "$GETME_SEEDS" -gt 10 ]`))
+
+	assert.Error(t, Run(context.Background(), Details{Hook: PreSnatch, Seeds: 5}))
+	assert.NoError(t, Run(context.Background(), Details{Hook: PreSnatch, Seeds: 50}))
+}
+
+func TestTimeout(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	defer Reset()
+
+	original := Timeout
+	Timeout = 50 * time.Millisecond
+	defer func() { Timeout = original }()
+
+	Set(PreSearch, script(t, dir, "exec sleep 5"))
+
+	start := time.Now()
+	err := Run(context.Background(), Details{Hook: PreSearch})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
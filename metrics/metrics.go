@@ -0,0 +1,277 @@
+// Package metrics counts what GetMe does and writes the counts in the
+// Prometheus text exposition format. The metrics are served by the daemon
+// and dumped for the node exporter's textfile collector after an update.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var registry = struct {
+	sync.RWMutex
+	metrics    map[string]*metric
+	collectors []func()
+}{metrics: make(map[string]*metric)}
+
+// metric is a family of values, one per combination of label values.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*value
+}
+
+type value struct {
+	labelValues []string
+	value       float64
+	// counts holds, for histograms, the number of observations per bucket.
+	counts []uint64
+	count  uint64
+}
+
+// Counter only goes up, like the number of searches.
+type Counter struct{ *metric }
+
+// Gauge goes up and down, like the number of pending episodes.
+type Gauge struct{ *metric }
+
+// Histogram counts observations, like request durations, in buckets.
+type Histogram struct{ *metric }
+
+// NewCounter registers a counter with the given labels.
+func NewCounter(name, help string, labels ...string) Counter {
+	return Counter{register(name, help, "counter", labels, nil)}
+}
+
+// NewGauge registers a gauge with the given labels.
+func NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{register(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogram registers a histogram with the given upper bounds of its
+// buckets and labels.
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return Histogram{register(name, help, "histogram", labels, sorted)}
+}
+
+func register(name, help, kind string, labels []string, buckets []float64) *metric {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.metrics[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*value),
+	}
+	registry.metrics[name] = m
+	return m
+}
+
+// OnCollect registers a function which is called every time the metrics are
+// written. It sets the gauges which are easier to compute on demand.
+func OnCollect(f func()) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.collectors = append(registry.collectors, f)
+}
+
+// Inc adds one to the counter.
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valueFor(labelValues).value += v
+}
+
+// Set sets the gauge to v.
+func (g Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.valueFor(labelValues).value = v
+}
+
+// Reset forgets every value of the gauge, for instance those of removed
+// shows.
+func (g Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = make(map[string]*value)
+}
+
+// Observe counts v in the histogram.
+func (h Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	val := h.valueFor(labelValues)
+	if val.counts == nil {
+		val.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			val.counts[i]++
+		}
+	}
+	val.value += v
+	val.count++
+}
+
+// valueFor returns the value for the label values. The caller holds the
+// metric's lock.
+func (m *metric) valueFor(labelValues []string) *value {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	val, ok := m.values[key]
+	if !ok {
+		val = &value{labelValues: append([]string(nil), labelValues...)}
+		m.values[key] = val
+	}
+	return val
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	registry.RLock()
+	collectors := registry.collectors
+	var names []string
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	registry.RUnlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	sort.Strings(names)
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		registry.RLock()
+		m := registry.metrics[name]
+		registry.RUnlock()
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	var keys []string
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		val := m.values[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels(m.labels, val.labelValues), formatFloat(val.value))
+			continue
+		}
+
+		names := with(m.labels, "le")
+		for i, bound := range m.buckets {
+			values := with(val.labelValues, formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(names, values), val.counts[i])
+		}
+		values := with(val.labelValues, "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(names, values), val.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels(m.labels, val.labelValues), formatFloat(val.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels(m.labels, val.labelValues), val.count)
+	}
+}
+
+// with returns a copy of s with v appended.
+func with(s []string, v string) []string {
+	return append(append([]string(nil), s...), v)
+}
+
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// WriteTextfile writes the metrics to path for the node exporter's textfile
+// collector. The file is replaced in one go so the collector never reads half
+// of it.
+func WriteTextfile(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
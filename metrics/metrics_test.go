@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testCounter   = NewCounter("test_searches_total", "Searches made.", "engine")
+	testGauge     = NewGauge("test_pending", "Pending \"episodes\".", "show")
+	testHistogram = NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.5})
+)
+
+// reset forgets the values of metrics, the tests can run more than once.
+func reset(metrics ...*metric) {
+	for _, m := range metrics {
+		m.mu.Lock()
+		m.values = make(map[string]*value)
+		m.mu.Unlock()
+	}
+}
+
+func TestWrite(t *testing.T) {
+	reset(testCounter.metric, testGauge.metric, testHistogram.metric)
+	testCounter.Inc("kickass")
+	testCounter.Add(2, "kickass")
+	testCounter.Inc("torrent\"cd")
+	testGauge.Set(3, "Show")
+	testHistogram.Observe(0.25)
+	testHistogram.Observe(0.75)
+	testHistogram.Observe(2)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Write(buf))
+
+	assert.Equal(t, `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.5"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 3
+test_duration_seconds_count 3
+# HELP test_pending Pending "episodes".
+# TYPE test_pending gauge
+test_pending{show="Show"} 3
+# HELP test_searches_total Searches made.
+# TYPE test_searches_total counter
+test_searches_total{engine="kickass"} 3
+test_searches_total{engine="torrent\"cd"} 1
+`, buf.String())
+
+	testGauge.Reset()
+	buf.Reset()
+	assert.NoError(t, Write(buf))
+	assert.NotContains(t, buf.String(), `test_pending{`)
+}
+
+func TestWrongNumberOfLabelsPanics(t *testing.T) {
+	assert.Panics(t, func() { testCounter.Inc() })
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, w.Body.String(), "# TYPE test_searches_total counter")
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "getme.prom")
+	assert.NoError(t, WriteTextfile(path))
+
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(written), "# TYPE test_pending gauge")
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "the temporary file is removed")
+}
@@ -0,0 +1,112 @@
+// Package notify tells the outside world what GetMe did. Events, like an
+// episode being snatched, are sent to the targets registered for their type:
+// a JSON webhook, an email or a command.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The types of events.
+const (
+	// Snatched is sent when a torrent for an episode or season was
+	// downloaded.
+	Snatched = "snatched"
+	// ShowEnded is sent when a source reports a show has ended.
+	ShowEnded = "show_ended"
+	// SearchFailing is sent when an episode wasn't found after repeated
+	// searches.
+	SearchFailing = "search_failing"
+	// SourceError is sent when a source failed to answer.
+	SourceError = "source_error"
+)
+
+// EventTypes lists every type of event.
+var EventTypes = []string{Snatched, ShowEnded, SearchFailing, SourceError}
+
+// Event is something which happened.
+type Event struct {
+	Type    string `json:"type"`
+	Show    string `json:"show,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+	// Torrent is the title of the snatched torrent.
+	Torrent string `json:"torrent,omitempty"`
+	// Source is the source which failed.
+	Source  string    `json:"source,omitempty"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// Subject returns a one line description of the event.
+func (e Event) Subject() string {
+	switch {
+	case e.Show != "" && e.Episode != 0:
+		return fmt.Sprintf("GetMe %s: %s S%02dE%02d", e.Type, e.Show, e.Season, e.Episode)
+	case e.Show != "" && e.Season != 0:
+		return fmt.Sprintf("GetMe %s: %s season %d", e.Type, e.Show, e.Season)
+	case e.Show != "":
+		return fmt.Sprintf("GetMe %s: %s", e.Type, e.Show)
+	}
+	return fmt.Sprintf("GetMe %s", e.Type)
+}
+
+// Target delivers events somewhere.
+type Target interface {
+	Notify(context.Context, Event) error
+	Name() string
+}
+
+// timeout is the time a target gets to deliver an event.
+var timeout = 10 * time.Second
+
+var targets = struct {
+	sync.RWMutex
+	byType map[string][]Target
+}{
+	byType: make(map[string][]Target),
+}
+
+// Register sends the events of a type to a target.
+func Register(eventType string, target Target) {
+	targets.Lock()
+	defer targets.Unlock()
+	targets.byType[eventType] = append(targets.byType[eventType], target)
+}
+
+// Reset forgets every registered target.
+func Reset() {
+	targets.Lock()
+	defer targets.Unlock()
+	targets.byType = make(map[string][]Target)
+}
+
+// Send delivers an event to the targets registered for its type. Failures
+// are logged, notifications are never worth failing for.
+func Send(ctx context.Context, event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	targets.RLock()
+	registered := targets.byType[event.Type]
+	targets.RUnlock()
+
+	for _, target := range registered {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		err := target.Notify(ctx, event)
+		cancel()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"target": target.Name(),
+				"event":  event.Type,
+			}).Error("Failed to send notification.")
+		}
+	}
+}
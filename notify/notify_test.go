@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var snatched = Event{
+	Type:    Snatched,
+	Show:    "Dead Set",
+	Season:  1,
+	Episode: 2,
+	Torrent: "Dead.Set.S01E02",
+	Message: "Downloaded Dead.Set.S01E02",
+}
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Notify(_ context.Context, event Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestSendOnlyToRegisteredTypes(t *testing.T) {
+	defer Reset()
+	r := &recorder{}
+	Register(Snatched, r)
+
+	Send(context.Background(), snatched)
+	Send(context.Background(), Event{Type: SourceError})
+
+	require.Equal(t, 1, len(r.events))
+	assert.False(t, r.events[0].At.IsZero())
+}
+
+func TestWebhook(t *testing.T) {
+	var received Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer ts.Close()
+
+	require.NoError(t, Webhook{URL: ts.URL}.Notify(context.Background(), snatched))
+	assert.Equal(t, "Dead.Set.S01E02", received.Torrent)
+}
+
+func TestWebhookFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	assert.Error(t, Webhook{URL: ts.URL}.Notify(context.Background(), snatched))
+}
+
+// smtpStandIn accepts one mail and sends its data on the returned channel.
+func smtpStandIn(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	mails := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		var data []string
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case inData && line == ".":
+				inData = false
+				mails <- strings.Join(data, "\n")
+				reply("250 OK")
+			case inData:
+				data = append(data, line)
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestEmail(t *testing.T) {
+	addr, mails := smtpStandIn(t)
+
+	email := Email{Server: addr, From: "getme@example.com", To: []string{"me@example.com"}}
+	require.NoError(t, email.Notify(context.Background(), snatched))
+
+	mail := <-mails
+	assert.Contains(t, mail, "Subject: GetMe snatched: Dead Set S01E02")
+	assert.Contains(t, mail, "Downloaded Dead.Set.S01E02")
+}
+
+func TestEmailHonoursContext(t *testing.T) {
+	// A server which accepts the connection but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	email := Email{Server: l.Addr().String(), From: "getme@example.com", To: []string{"me@example.com"}}
+	assert.Error(t, email.Notify(ctx, snatched))
+	assert.True(t, time.Since(start) < time.Second, "gave up when the context was done")
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	script := path.Join(dir, "notify.sh")
+	out := path.Join(dir, "out")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$GETME_EVENT $GETME_SHOW\" > "+out+"\ncat >> "+out+"\n"), 0755)
+
+	require.NoError(t, Command{Path: script}.Notify(context.Background(), snatched))
+
+	written, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(written), "snatched Dead Set\n{"))
+	assert.Contains(t, string(written), `"torrent":"Dead.Set.S01E02"`)
+}
+
+func TestFailingCommand(t *testing.T) {
+	assert.Error(t, Command{Path: "/bin/false"}.Notify(context.Background(), snatched))
+}
@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haarts/getme/request"
+)
+
+// Webhook posts events as JSON to a URL.
+type Webhook struct {
+	URL string
+}
+
+func (w Webhook) Name() string {
+	return "webhook"
+}
+
+func (w Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "github.com/haarts/getme")
+
+	resp, err := request.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Email mails events through an SMTP server. Username and Password are only
+// used when set.
+type Email struct {
+	// Server is the address of the SMTP server, like 'localhost:25'.
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e Email) Name() string {
+	return "email"
+}
+
+// Notify sends the mail. A server which doesn't answer before the context is
+// done, or within timeout when the context has no deadline, fails it.
+func (e Email) Notify(ctx context.Context, event Event) error {
+	details, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n\r\n%s\r\n",
+		e.From,
+		strings.Join(e.To, ", "),
+		event.Subject(),
+		event.Message,
+		details,
+	)
+	return e.send(ctx, []byte(msg))
+}
+
+// send is smtp.SendMail on a connection which honours the context.
+func (e Email) send(ctx context.Context, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", e.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Cancelling the context interrupts the conversation too.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	host := strings.Split(e.Server, ":")[0]
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Command runs a program for every event. The event is passed as JSON on
+// stdin and as GETME_* environment variables.
+type Command struct {
+	Path string
+}
+
+func (c Command) Name() string {
+	return "command"
+}
+
+func (c Command) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(
+		os.Environ(),
+		"GETME_EVENT="+event.Type,
+		"GETME_SHOW="+event.Show,
+		"GETME_SEASON="+strconv.Itoa(event.Season),
+		"GETME_EPISODE="+strconv.Itoa(event.Episode),
+		"GETME_TORRENT="+event.Torrent,
+		"GETME_SOURCE="+event.Source,
+		"GETME_MESSAGE="+event.Message,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
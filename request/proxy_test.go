@@ -0,0 +1,139 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socks5StandIn accepts one SOCKS5 connection with username/password
+// authentication and answers the HTTP request tunneled through it itself.
+func socks5StandIn(t *testing.T, user, password string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		// greeting: version, methods
+		header := make([]byte, 2)
+		io.ReadFull(r, header)
+		io.ReadFull(r, make([]byte, header[1]))
+		conn.Write([]byte{5, 2})
+
+		// username/password authentication
+		io.ReadFull(r, header[:1])
+		gotUser := readPrefixed(r)
+		gotPassword := readPrefixed(r)
+		if gotUser != user || gotPassword != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		// connect request: version, command, reserved, address type
+		request := make([]byte, 4)
+		io.ReadFull(r, request)
+		switch request[3] {
+		case 1:
+			io.ReadFull(r, make([]byte, 4))
+		case 3:
+			readPrefixed(r)
+		}
+		io.ReadFull(r, make([]byte, 2))
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nsocks")
+		req.Body.Close()
+	}()
+
+	return l
+}
+
+func readPrefixed(r *bufio.Reader) string {
+	length, _ := r.ReadByte()
+	b := make([]byte, length)
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func get(t *testing.T, client *http.Client, URL string) string {
+	resp, err := client.Get(URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestProxies(t *testing.T) {
+	defer ResetProxies()
+
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "http://indexer.invalid/search", r.RequestURI)
+		user, password, _ := (&http.Request{Header: http.Header{
+			"Authorization": r.Header["Proxy-Authorization"],
+		}}).BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "secret", password)
+		fmt.Fprint(w, "http")
+	}))
+	defer httpProxy.Close()
+
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "direct")
+	}))
+	defer direct.Close()
+
+	socks := socks5StandIn(t, "user", "secret")
+	defer socks.Close()
+
+	proxy, err := ParseProxy("http://user:secret@" + HostOf(httpProxy.URL))
+	require.NoError(t, err)
+	SetGlobalProxy(proxy)
+
+	proxy, err = ParseProxy("socks5://user:secret@" + socks.Addr().String())
+	require.NoError(t, err)
+	SetProxy("metadata.invalid", proxy)
+
+	proxy, err = ParseProxy(Direct)
+	require.NoError(t, err)
+	SetProxy(HostOf(direct.URL), proxy)
+
+	client := &http.Client{Transport: newTransport()}
+	assert.Equal(t, "http", get(t, client, "http://indexer.invalid/search"))
+	assert.Equal(t, "socks", get(t, client, "http://metadata.invalid/shows"))
+	assert.Equal(t, "direct", get(t, client, direct.URL))
+}
+
+func TestParseProxy(t *testing.T) {
+	_, err := ParseProxy("ftp://localhost")
+	assert.Error(t, err)
+
+	proxy, err := ParseProxy(Direct)
+	assert.NoError(t, err)
+	assert.Nil(t, proxy)
+
+	proxy, err = ParseProxy("socks5://localhost:1080")
+	require.NoError(t, err)
+	assert.Equal(t, &url.URL{Scheme: "socks5", Host: "localhost:1080"}, proxy)
+}
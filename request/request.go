@@ -0,0 +1,227 @@
+// Package request provides the HTTP client shared by every source and search
+// engine. It rate limits requests per host, retries requests which failed
+// for transient reasons and times requests out.
+package request
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Client rate limits and retries requests. It implements http.RoundTripper
+// so it can be handed to third party API clients as well.
+type Client struct {
+	// Transport does the actual requests.
+	Transport http.RoundTripper
+	// Retries is the number of times a request is retried after a network
+	// error, a 429 or a 5xx response.
+	Retries int
+	// Backoff is the wait before the first retry. It doubles with every
+	// retry, unless the server tells us how long to wait with Retry-After.
+	Backoff time.Duration
+	// Interval is the time it takes for a host to earn a request. Burst is
+	// the number of requests a host can save up.
+	Interval time.Duration
+	Burst    int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds the requests a host has left. It goes negative when requests
+// are waiting for their turn.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Default is the Client used by Do and HTTPClient.
+var Default = &Client{
+	Transport: newTransport(),
+	Retries:   3,
+	Backoff:   1 * time.Second,
+	Interval:  500 * time.Millisecond,
+	Burst:     5,
+}
+
+// Timeout is the time a request, including its retries and reading the
+// response body, may take.
+var Timeout = 30 * time.Second
+
+// Configure overrides the defaults. Zero values leave the default in place.
+func Configure(timeout time.Duration, retries int, interval time.Duration) {
+	if timeout != 0 {
+		Timeout = timeout
+	}
+	if retries != 0 {
+		Default.Retries = retries
+	}
+	if interval != 0 {
+		Default.Interval = interval
+	}
+}
+
+// HTTPClient returns an http.Client which uses the Default client.
+func HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: Default,
+		Timeout:   Timeout,
+	}
+}
+
+// HTTPClientFor returns an http.Client like HTTPClient whose requests are
+// cancelled with ctx. It is meant for third party API clients which don't
+// take a context themselves.
+func HTTPClientFor(ctx context.Context) *http.Client {
+	return &http.Client{
+		Transport: boundTransport{ctx: ctx, next: Default},
+		Timeout:   Timeout,
+	}
+}
+
+// boundTransport sends every request with the context it is bound to.
+type boundTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (b boundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return b.next.RoundTrip(req.WithContext(b.ctx))
+}
+
+// admittedKey marks, in the context of a request, that the request already
+// waited for its turn.
+type admittedKey struct{}
+
+// Do sends a request with the Default client. The request waits for its turn
+// before Timeout starts ticking. It is cancelled when its context is.
+func Do(req *http.Request) (*http.Response, error) {
+	if err := Default.wait(req); err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(req.Context(), admittedKey{}, true)
+	return HTTPClient().Do(req.WithContext(ctx))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || req.Context().Value(admittedKey{}) == nil {
+			if err = c.wait(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = c.Transport.RoundTrip(req)
+		if attempt >= c.Retries || !retryable(resp, err) || !rewindable(req) {
+			return resp, err
+		}
+
+		delay := c.Backoff << uint(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+			}
+			resp.Body.Close()
+		}
+
+		log.WithFields(log.Fields{
+			"URL":     req.URL.String(),
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Info("Retrying request")
+
+		if err = sleep(req, delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// wait blocks until the host of the request has earned a request.
+func (c *Client) wait(req *http.Request) error {
+	c.mu.Lock()
+	if c.buckets == nil {
+		c.buckets = make(map[string]*bucket)
+	}
+	b, ok := c.buckets[req.URL.Host]
+	if !ok {
+		b = &bucket{tokens: float64(c.Burst), last: time.Now()}
+		c.buckets[req.URL.Host] = b
+	}
+
+	now := time.Now()
+	if c.Interval > 0 {
+		b.tokens += float64(now.Sub(b.last)) / float64(c.Interval)
+	}
+	if b.tokens > float64(c.Burst) {
+		b.tokens = float64(c.Burst)
+	}
+	b.last = now
+	b.tokens--
+
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens * float64(c.Interval))
+	}
+	c.mu.Unlock()
+
+	return sleep(req, delay)
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// rewindable tells whether a request can be sent again.
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryAfter parses the Retry-After header, which is either a number of
+// seconds or a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// sleep waits for d unless the request is cancelled first.
+func sleep(req *http.Request, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
@@ -0,0 +1,116 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClient() *Client {
+	return &Client{
+		Transport: http.DefaultTransport,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+		Interval:  time.Millisecond,
+		Burst:     10,
+	}
+}
+
+func TestRetryOnServerErrors(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := (&http.Client{Transport: testClient()}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, requests)
+}
+
+func TestGiveUpAfterRetries(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := (&http.Client{Transport: testClient()}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, 3, requests)
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := (&http.Client{Transport: testClient()}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 1, requests)
+}
+
+func TestRateLimitPerHost(t *testing.T) {
+	c := testClient()
+	c.Interval = 20 * time.Millisecond
+	c.Burst = 1
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	other, _ := http.NewRequest("GET", "http://example.org", nil)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.wait(req))
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 40*time.Millisecond, "waited %s", elapsed)
+
+	// Another host isn't held up by the first one, which waits an hour.
+	c.Interval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, c.wait(other.WithContext(ctx)))
+}
+
+func TestWaitIsCancelled(t *testing.T) {
+	c := testClient()
+	c.Interval = time.Hour
+	c.Burst = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req = req.WithContext(ctx)
+
+	require.NoError(t, c.wait(req))
+	cancel()
+	assert.Equal(t, context.Canceled, c.wait(req))
+}
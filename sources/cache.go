@@ -0,0 +1,195 @@
+package sources
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// cache is the on disk HTTP cache used for requests to sources. It is nil,
+// and thus disabled, until EnableCache is called. Search engines are never
+// cached, only requests to the URL of a source are.
+var cache *httpCache
+
+type httpCache struct {
+	dir    string
+	ttls   map[string]time.Duration
+	bypass bool
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	// MaxAge is the freshness lifetime the server gave us.
+	MaxAge time.Duration `json:"max_age"`
+	// Revalidate is set when the server wants us to always check with it.
+	Revalidate bool `json:"revalidate"`
+}
+
+// EnableCache stores responses from sources in dir. A response is served
+// from the cache, without contacting the source, while it is younger than
+// the TTL configured for the source or the max-age the source sent, whichever
+// is longer. Stale responses are revalidated with ETag and Last-Modified.
+func EnableCache(dir string, ttls map[string]time.Duration) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	cache = &httpCache{
+		dir:  dir,
+		ttls: ttls,
+	}
+	return nil
+}
+
+// BypassCache makes every request go to the sources. The responses are still
+// stored so the next run benefits from them.
+func BypassCache() {
+	if cache != nil {
+		cache.bypass = true
+	}
+}
+
+// ClearCache removes every cached response from dir.
+func ClearCache(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(path.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceFor returns the name of the source a request is meant for, if any.
+func sourceFor(req *http.Request) string {
+	URL := req.URL.String()
+	switch {
+	case strings.HasPrefix(URL, tvMazeURL):
+		return tvMazeName
+	case strings.HasPrefix(URL, tvRageURL):
+		return tvRageName
+	}
+	return ""
+}
+
+// lookup returns the cached entry for a request. The returned bool tells
+// whether the entry is fresh enough to be used as is. A request can demand
+// revalidation with 'Cache-Control: no-cache'.
+func (c *httpCache) lookup(req *http.Request) (*cacheEntry, bool) {
+	if req.Method != "GET" || sourceFor(req) == "" {
+		return nil, false
+	}
+
+	d, err := ioutil.ReadFile(c.fileFor(req))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(d, &entry); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"URL": req.URL.String(),
+		}).Warn("Ignoring corrupt cache entry.")
+		return nil, false
+	}
+
+	if c.bypass || entry.Revalidate || strings.Contains(req.Header.Get("Cache-Control"), "no-cache") {
+		return &entry, false
+	}
+
+	ttl := c.ttls[sourceFor(req)]
+	if entry.MaxAge > ttl {
+		ttl = entry.MaxAge
+	}
+	return &entry, time.Since(entry.FetchedAt) < ttl
+}
+
+// store saves the response body for a request. Responses the server doesn't
+// want us to store are skipped.
+func (c *httpCache) store(req *http.Request, header http.Header, body []byte) {
+	if req.Method != "GET" || sourceFor(req) == "" {
+		return
+	}
+
+	entry := cacheEntry{
+		URL:          req.URL.String(),
+		Body:         body,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return
+		case directive == "no-cache":
+			entry.Revalidate = true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				entry.MaxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	c.write(req, entry)
+}
+
+// refresh marks an entry as fresh again after the source told us it hasn't
+// changed.
+func (c *httpCache) refresh(req *http.Request, entry *cacheEntry) {
+	entry.FetchedAt = time.Now()
+	c.write(req, *entry)
+}
+
+func (c *httpCache) write(req *http.Request, entry cacheEntry) {
+	b, err := json.Marshal(entry)
+	if err == nil {
+		err = ioutil.WriteFile(c.fileFor(req), b, 0644)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"URL": req.URL.String(),
+		}).Warn("Failed to cache response.")
+	}
+}
+
+func (c *httpCache) fileFor(req *http.Request) string {
+	sum := sha1.Sum([]byte(req.URL.String()))
+	return path.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// conditional adds the headers which allow the source to answer with 304 Not
+// Modified.
+func (e *cacheEntry) conditional(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
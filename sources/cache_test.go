@@ -0,0 +1,72 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedSeasons(t *testing.T) {
+	var requests, notModified int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `[{"name": "Pilot", "season": 1, "number": 1}]`)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "getme_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldURL := tvMazeURL
+	tvMazeURL = ts.URL
+	defer func() {
+		tvMazeURL = oldURL
+		cache = nil
+	}()
+
+	require.NoError(t, EnableCache(dir, map[string]time.Duration{tvMazeName: time.Hour}))
+
+	for i := 0; i < 2; i++ {
+		seasons, err := TvMaze{}.Seasons(context.Background(), &store.Show{ID: 1})
+		require.NoError(t, err)
+		require.Len(t, seasons, 1)
+	}
+	assert.Equal(t, 1, requests, "second request is served from cache")
+
+	BypassCache()
+	seasons, err := TvMaze{}.Seasons(context.Background(), &store.Show{ID: 1})
+	require.NoError(t, err)
+	require.Len(t, seasons, 1)
+	assert.Equal(t, "Pilot", seasons[0].Episodes[0].Title)
+	assert.Equal(t, 1, notModified, "bypassing the cache still revalidates")
+
+	require.NoError(t, ClearCache(dir))
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestUncachedResponses(t *testing.T) {
+	c := &httpCache{dir: "does_not_matter"}
+	req, _ := http.NewRequest("GET", "http://example.com/search", nil)
+
+	entry, fresh := c.lookup(req)
+	assert.Nil(t, entry, "requests to search engines are never cached")
+	assert.False(t, fresh)
+}
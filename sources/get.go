@@ -11,6 +11,8 @@ import (
 	"strconv"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/request"
 )
 
 type RequestError struct {
@@ -22,8 +24,18 @@ func (r RequestError) Error() string {
 	return fmt.Sprintf("%s, response code: %d", r.Message, r.ResponseCode)
 }
 
+// Hosts returns, per source, the hosts the source sends requests to.
+func Hosts() map[string][]string {
+	return map[string][]string{
+		tvMazeName: {request.HostOf(tvMazeURL)},
+		traktName:  {request.HostOf(traktURL)},
+		tvRageName: {request.HostOf(tvRageURL)},
+	}
+}
+
 // GetJSON abstracts away from the usual log/connect/retry logic involving GET
-// requests. This particular version unmarshals JSON.
+// requests. This particular version unmarshals JSON. Retrying and rate
+// limiting is left to the request package.
 func GetJSON(req *http.Request, target interface{}) error {
 	return get(req, target, json.Unmarshal)
 }
@@ -41,10 +53,26 @@ func get(req *http.Request, target interface{}, unmarshalFunc func([]byte, inter
 			"URL": req.URL.String(),
 		}).Debug("Request")
 
+	var cached *cacheEntry
+	if cache != nil {
+		var fresh bool
+		cached, fresh = cache.lookup(req)
+		if fresh {
+			log.WithFields(
+				log.Fields{
+					"URL": req.URL.String(),
+				}).Debug("Served from cache")
+			return unmarshalFunc(cached.Body, target)
+		}
+		if cached != nil {
+			cached.conditional(req)
+		}
+	}
+
 	// Be nice and tell them who we are.
 	req.Header.Set("User-Agent", "github.com/haarts/getme")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := request.Do(req)
 
 	defer func() {
 		if resp != nil {
@@ -57,7 +85,12 @@ func get(req *http.Request, target interface{}, unmarshalFunc func([]byte, inter
 				"error": err,
 				"URL":   req.URL.String(),
 			}).Error("GET error")
-		return err //TODO retry a couple of times when it's a timeout.
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		cache.refresh(req, cached)
+		return unmarshalFunc(cached.Body, target)
 	}
 
 	if resp.StatusCode != 200 {
@@ -83,5 +116,9 @@ func get(req *http.Request, target interface{}, unmarshalFunc func([]byte, inter
 		return err
 	}
 
+	if cache != nil {
+		cache.store(req, resp.Header, body)
+	}
+
 	return nil
 }
@@ -0,0 +1,320 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/notify"
+	"github.com/haarts/getme/store"
+)
+
+// titleAuthority and airDateAuthority list, most trusted first, which source
+// wins when sources disagree on the title or the air date of an episode.
+// TvMaze tends to have accurate air stamps while Trakt has the better titles.
+var titleAuthority = []string{traktName, tvMazeName, tvRageName}
+var airDateAuthority = []string{tvMazeName, traktName, tvRageName}
+
+// airDateTolerance is the difference in air dates we consider noise. Sources
+// don't agree on time zones.
+const airDateTolerance = 24 * time.Hour
+
+// Discrepancy describes one disagreement between sources on an episode.
+type Discrepancy struct {
+	Season  int
+	Episode int
+	// Field is one of "title", "air_date" or "missing".
+	Field string
+	// Values maps source names to what that source claims.
+	Values map[string]string
+}
+
+func (d Discrepancy) String() string {
+	var names []string
+	for name := range d.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var claims []string
+	for _, name := range names {
+		claims = append(claims, fmt.Sprintf("%s: %q", name, d.Values[name]))
+	}
+
+	return fmt.Sprintf(
+		"S%02dE%02d %s differs (%s)",
+		d.Season,
+		d.Episode,
+		d.Field,
+		strings.Join(claims, ", "),
+	)
+}
+
+// Link searches the sources the show isn't linked to yet for a show with the
+// same title and records the IDs found. Ambiguous results are ignored.
+func Link(ctx context.Context, show *store.Show) {
+	show.LinkSource(show.SourceName, show.ID)
+
+	for name, source := range sources {
+		if _, ok := show.IDFor(name); ok {
+			continue
+		}
+
+		result := source.Search(ctx, show.Title)
+		if result.Error != nil {
+			log.WithFields(log.Fields{
+				"err":    result.Error,
+				"show":   show.Title,
+				"source": name,
+			}).Warn("Failed to link show to source.")
+			continue
+		}
+
+		match, ok := uniqueTitleMatch(show.Title, result.Shows)
+		if !ok {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"show":   show.Title,
+			"source": name,
+			"id":     match.ID,
+		}).Info("Linked show to source.")
+		show.LinkSource(name, match.ID)
+	}
+}
+
+func uniqueTitleMatch(title string, candidates []Show) (Show, bool) {
+	var matches []Show
+	for _, candidate := range candidates {
+		if normalizeTitle(candidate.Title) == normalizeTitle(title) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) != 1 {
+		return Show{}, false
+	}
+	return matches[0], true
+}
+
+func normalizeTitle(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), " "))
+}
+
+// Reconcile fetches the seasons of a show from its own source and from every
+// other source it is linked to. The results are merged into one list of
+// seasons. The show itself is left untouched.
+//
+// The episodes known to the most trusted source which answered make up the
+// list, titles and air dates are then taken from the authorities listed in
+// titleAuthority and airDateAuthority. Every disagreement is reported.
+func Reconcile(ctx context.Context, show *store.Show) ([]Season, []Discrepancy, error) {
+	names := linkedSources(show)
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no available source for show %s", show.Title)
+	}
+
+	fetched := map[string][]Season{}
+	var err error
+	for _, name := range names {
+		ID, _ := show.IDFor(name)
+		linked := *show
+		linked.ID = ID
+		linked.SourceName = name
+
+		start := time.Now()
+		seasons, e := sources[name].Seasons(ctx, &linked)
+		sourceLatency.Observe(time.Since(start).Seconds(), name, "seasons")
+		if e != nil {
+			log.WithFields(log.Fields{
+				"err":    e,
+				"show":   show.Title,
+				"source": name,
+			}).Warn("Source failed to return seasons.")
+			notify.Send(ctx, notify.Event{
+				Type:    notify.SourceError,
+				Show:    show.Title,
+				Source:  name,
+				Message: fmt.Sprintf("%s failed to return seasons: %s", name, e),
+			})
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		fetched[name] = seasons
+	}
+
+	if len(fetched) == 0 {
+		return nil, nil, err
+	}
+
+	seasons, discrepancies := merge(names, fetched)
+	return seasons, discrepancies, nil
+}
+
+// linkedSources returns the names of the available sources the show is linked
+// to. The show's own source comes first.
+func linkedSources(show *store.Show) []string {
+	var names []string
+	if _, ok := sources[show.SourceName]; ok {
+		names = append(names, show.SourceName)
+	}
+
+	var others []string
+	for name := range show.ExternalIDs {
+		if _, ok := sources[name]; ok && name != show.SourceName {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+
+	return append(names, others...)
+}
+
+type episodeKey struct {
+	season, episode int
+}
+
+func merge(names []string, fetched map[string][]Season) ([]Season, []Discrepancy) {
+	episodes := map[string]map[episodeKey]Episode{}
+	for name, seasons := range fetched {
+		episodes[name] = map[episodeKey]Episode{}
+		for _, season := range seasons {
+			for _, episode := range season.Episodes {
+				episodes[name][episodeKey{season.Season, episode.Episode}] = episode
+			}
+		}
+	}
+
+	var skeleton string
+	for _, name := range names {
+		if _, ok := fetched[name]; ok {
+			skeleton = name
+			break
+		}
+	}
+
+	var discrepancies []Discrepancy
+	var merged []Season
+	for _, season := range fetched[skeleton] {
+		mergedSeason := Season{Season: season.Season}
+		for _, episode := range season.Episodes {
+			key := episodeKey{season.Season, episode.Episode}
+			mergedEpisode, found := mergeEpisode(key, skeleton, episodes)
+			discrepancies = append(discrepancies, found...)
+			mergedSeason.Episodes = append(mergedSeason.Episodes, mergedEpisode)
+		}
+		merged = append(merged, mergedSeason)
+	}
+
+	// Episodes the skeleton doesn't know about are reported but not added.
+	// These are the phantom episodes we want to avoid.
+	for name, known := range episodes {
+		for key, episode := range known {
+			if _, ok := episodes[skeleton][key]; ok {
+				continue
+			}
+			discrepancies = append(discrepancies, Discrepancy{
+				Season:  key.season,
+				Episode: key.episode,
+				Field:   "missing",
+				Values: map[string]string{
+					name:     episode.Title,
+					skeleton: "",
+				},
+			})
+		}
+	}
+
+	sort.Sort(byEpisode(discrepancies))
+	return merged, discrepancies
+}
+
+func mergeEpisode(key episodeKey, skeleton string, episodes map[string]map[episodeKey]Episode) (Episode, []Discrepancy) {
+	claims := map[string]Episode{}
+	for name, known := range episodes {
+		if episode, ok := known[key]; ok {
+			claims[name] = episode
+		}
+	}
+
+	merged := claims[skeleton]
+	for _, name := range titleAuthority {
+		if episode, ok := claims[name]; ok && episode.Title != "" {
+			merged.Title = episode.Title
+			break
+		}
+	}
+	for _, name := range airDateAuthority {
+		if episode, ok := claims[name]; ok && !episode.AirDate.IsZero() {
+			merged.AirDate = episode.AirDate
+			break
+		}
+	}
+	for _, episode := range claims {
+		if merged.Runtime == 0 {
+			merged.Runtime = episode.Runtime
+		}
+		if merged.Absolute == 0 {
+			merged.Absolute = episode.Absolute
+		}
+	}
+
+	var titleConflict, airDateConflict bool
+	titles := map[string]string{}
+	airDates := map[string]string{}
+	for name, episode := range claims {
+		titles[name] = episode.Title
+		airDates[name] = episode.AirDate.Format("2006-01-02")
+
+		if !strings.EqualFold(episode.Title, merged.Title) {
+			titleConflict = true
+		}
+		if episode.AirDate.IsZero() {
+			continue
+		}
+		if diff := episode.AirDate.Sub(merged.AirDate); diff > airDateTolerance || diff < -airDateTolerance {
+			airDateConflict = true
+		}
+	}
+
+	var discrepancies []Discrepancy
+	if titleConflict {
+		discrepancies = append(discrepancies, Discrepancy{
+			Season:  key.season,
+			Episode: key.episode,
+			Field:   "title",
+			Values:  titles,
+		})
+	}
+	if airDateConflict {
+		discrepancies = append(discrepancies, Discrepancy{
+			Season:  key.season,
+			Episode: key.episode,
+			Field:   "air_date",
+			Values:  airDates,
+		})
+	}
+
+	return merged, discrepancies
+}
+
+// Sorts discrepancies by season, episode and field.
+type byEpisode []Discrepancy
+
+func (a byEpisode) Len() int      { return len(a) }
+func (a byEpisode) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEpisode) Less(i, j int) bool {
+	if a[i].Season != a[j].Season {
+		return a[i].Season < a[j].Season
+	}
+	if a[i].Episode != a[j].Episode {
+		return a[i].Episode < a[j].Episode
+	}
+	return a[i].Field < a[j].Field
+}
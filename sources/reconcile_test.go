@@ -0,0 +1,61 @@
+package sources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePrefersAuthorities(t *testing.T) {
+	aired := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	fetched := map[string][]Season{
+		tvMazeName: {{Season: 1, Episodes: []Episode{
+			{Episode: 1, Title: "pilot", AirDate: aired},
+			{Episode: 2, Title: "TBA", AirDate: aired.Add(7 * 24 * time.Hour)},
+		}}},
+		traktName: {{Season: 1, Episodes: []Episode{
+			{Episode: 1, Title: "Pilot", AirDate: aired.Add(2 * time.Hour)},
+			{Episode: 2, Title: "The Second", AirDate: aired.Add(5 * 24 * time.Hour)},
+			{Episode: 3, Title: "Phantom"},
+		}}},
+	}
+
+	seasons, discrepancies := merge([]string{tvMazeName, traktName}, fetched)
+
+	require.Len(t, seasons, 1)
+	require.Len(t, seasons[0].Episodes, 2, "episodes unknown to the primary source are not added")
+	assert.Equal(t, "Pilot", seasons[0].Episodes[0].Title)
+	assert.Equal(t, aired, seasons[0].Episodes[0].AirDate)
+	assert.Equal(t, "The Second", seasons[0].Episodes[1].Title)
+	assert.Equal(t, aired.Add(7*24*time.Hour), seasons[0].Episodes[1].AirDate)
+
+	require.Len(t, discrepancies, 3)
+	assert.Equal(t, "air_date", discrepancies[0].Field)
+	assert.Equal(t, 2, discrepancies[0].Episode)
+	assert.Equal(t, "title", discrepancies[1].Field)
+	assert.Equal(t, "missing", discrepancies[2].Field)
+	assert.Equal(t, 3, discrepancies[2].Episode)
+}
+
+func TestMergeWithOnlyFallback(t *testing.T) {
+	fetched := map[string][]Season{
+		traktName: {{Season: 1, Episodes: []Episode{{Episode: 1, Title: "Pilot"}}}},
+	}
+
+	seasons, discrepancies := merge([]string{tvMazeName, traktName}, fetched)
+
+	require.Len(t, seasons, 1)
+	assert.Equal(t, "Pilot", seasons[0].Episodes[0].Title)
+	assert.Empty(t, discrepancies)
+}
+
+func TestUniqueTitleMatch(t *testing.T) {
+	_, ok := uniqueTitleMatch("Dead Set", []Show{{Title: "dead  set"}, {Title: "Dead Set"}})
+	assert.False(t, ok, "ambiguous matches are ignored")
+
+	match, ok := uniqueTitleMatch("Dead Set", []Show{{Title: "Dead Set", ID: 476}, {Title: "Dead"}})
+	assert.True(t, ok)
+	assert.Equal(t, 476, match.ID)
+}
@@ -0,0 +1,114 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/store"
+)
+
+// Confirm is asked whether a candidate found on another source is the same
+// show as the one being relinked.
+type Confirm func(show *store.Show, candidate Show) bool
+
+// Relink moves a show whose source is no longer available to another source.
+// The remaining sources are searched by title. A candidate is accepted
+// without asking when it is the one the show was linked to before or when it
+// is the only one with the same title which premiered in the same year.
+// Otherwise confirm is consulted for every candidate, confirm may be nil.
+//
+// Only the source, ID and URL of the show are rewritten. The seasons and
+// episodes, and thus which are still pending, are kept.
+func Relink(ctx context.Context, show *store.Show, confirm Confirm) error {
+	candidates := relinkCandidates(ctx, show)
+
+	candidate, ok := acceptedByHeuristics(show, candidates)
+	if !ok && confirm != nil {
+		for _, c := range candidates {
+			if confirm(show, c) {
+				candidate, ok = c, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("no replacement found for source %s of %s", show.SourceName, show.Title)
+	}
+
+	log.WithFields(log.Fields{
+		"show":       show.Title,
+		"old_source": show.SourceName,
+		"new_source": candidate.Source,
+		"id":         candidate.ID,
+	}).Info("Relinked show.")
+
+	show.LinkSource(show.SourceName, show.ID)
+	show.SourceName = candidate.Source
+	show.ID = candidate.ID
+	show.URL = candidate.URL
+	show.LinkSource(candidate.Source, candidate.ID)
+
+	return nil
+}
+
+// relinkCandidates searches the available sources, other than the show's
+// own, for shows with the same title. Those which premiered in the same year
+// as the show come first.
+func relinkCandidates(ctx context.Context, show *store.Show) []Show {
+	year := show.FirstAired().Year()
+
+	var sameYear, otherYears []Show
+	for name, source := range sources {
+		if name == show.SourceName {
+			continue
+		}
+
+		result := source.Search(ctx, show.Title)
+		if result.Error != nil {
+			log.WithFields(log.Fields{
+				"err":    result.Error,
+				"show":   show.Title,
+				"source": name,
+			}).Warn("Failed to search source for replacement.")
+			continue
+		}
+
+		for _, candidate := range result.Shows {
+			if normalizeTitle(candidate.Title) != normalizeTitle(show.Title) {
+				continue
+			}
+			if candidate.Year == year {
+				sameYear = append(sameYear, candidate)
+			} else {
+				otherYears = append(otherYears, candidate)
+			}
+		}
+	}
+
+	return append(sameYear, otherYears...)
+}
+
+func acceptedByHeuristics(show *store.Show, candidates []Show) (Show, bool) {
+	for _, candidate := range candidates {
+		if ID, ok := show.IDFor(candidate.Source); ok && ID == candidate.ID {
+			return candidate, true
+		}
+	}
+
+	if show.FirstAired().IsZero() {
+		return Show{}, false
+	}
+
+	var sameYear []Show
+	for _, candidate := range candidates {
+		if candidate.Year == show.FirstAired().Year() {
+			sameYear = append(sameYear, candidate)
+		}
+	}
+	if len(sameYear) != 1 {
+		return Show{}, false
+	}
+	return sameYear[0], true
+}
@@ -0,0 +1,99 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	name  string
+	shows []Show
+}
+
+func (f fakeSource) Name() string { return f.name }
+
+func (f fakeSource) Search(_ context.Context, q string) SearchResult {
+	return SearchResult{Name: f.name, Shows: f.shows}
+}
+
+func (f fakeSource) Seasons(_ context.Context, show *store.Show) ([]Season, error) {
+	return nil, errors.New("not implemented")
+}
+
+// withSources replaces the sources, call the returned func to restore them.
+func withSources(replacements ...Source) func() {
+	original := sources
+	sources = map[string]Source{}
+	for _, s := range replacements {
+		sources[s.Name()] = s
+	}
+	return func() { sources = original }
+}
+
+func orphanedShow() *store.Show {
+	return &store.Show{
+		Title:      "Dead Set",
+		ID:         20204,
+		SourceName: tvRageName,
+		Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+			{Episode: 1, AirDate: time.Date(2008, 10, 27, 0, 0, 0, 0, time.UTC)},
+			{Episode: 2, Pending: true},
+		}}},
+	}
+}
+
+func TestRelinkBySameYear(t *testing.T) {
+	defer withSources(fakeSource{name: tvMazeName, shows: []Show{
+		{Title: "Dead Set", ID: 1, Year: 2013, Source: tvMazeName},
+		{Title: "Dead Set", ID: 476, Year: 2008, Source: tvMazeName, URL: "url"},
+		{Title: "Dead Man", ID: 2, Year: 2008, Source: tvMazeName},
+	}})()
+
+	show := orphanedShow()
+	require.NoError(t, Relink(context.Background(), show, nil))
+
+	assert.Equal(t, tvMazeName, show.SourceName)
+	assert.Equal(t, 476, show.ID)
+	assert.Equal(t, "url", show.URL)
+	assert.True(t, show.Seasons[0].Episodes[1].Pending)
+	assert.False(t, show.Seasons[0].Episodes[0].Pending)
+}
+
+func TestRelinkAsksWhenAmbiguous(t *testing.T) {
+	defer withSources(fakeSource{name: tvMazeName, shows: []Show{
+		{Title: "Dead Set", ID: 1, Year: 2013, Source: tvMazeName},
+	}})()
+
+	show := orphanedShow()
+	assert.Error(t, Relink(context.Background(), show, nil))
+	assert.Equal(t, tvRageName, show.SourceName)
+
+	var asked []int
+	err := Relink(context.Background(), show, func(_ *store.Show, candidate Show) bool {
+		asked = append(asked, candidate.ID)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, asked)
+	assert.Equal(t, 1, show.ID)
+}
+
+func TestRelinkToPreviouslyLinkedSource(t *testing.T) {
+	defer withSources(fakeSource{name: traktName, shows: []Show{
+		{Title: "Dead Set", ID: 1, Source: traktName},
+		{Title: "Dead Set", ID: 2, Source: traktName},
+	}})()
+
+	show := orphanedShow()
+	show.LinkSource(traktName, 2)
+	require.NoError(t, Relink(context.Background(), show, nil))
+
+	assert.Equal(t, traktName, show.SourceName)
+	assert.Equal(t, 2, show.ID)
+}
@@ -3,10 +3,14 @@
 package sources
 
 import (
+	"context"
+	"sort"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/metrics"
+	"github.com/haarts/getme/notify"
 	"github.com/haarts/getme/store"
 )
 
@@ -17,9 +21,10 @@ type Match interface {
 }
 
 // Source is an external data source which can be search for show data.
+// The context cancels the requests made on behalf of a search or lookup.
 type Source interface {
-	Search(string) SearchResult
-	Seasons(*store.Show) ([]Season, error)
+	Search(context.Context, string) SearchResult
+	Seasons(context.Context, *store.Show) ([]Season, error)
 	Name() string
 }
 
@@ -38,6 +43,14 @@ type Show struct {
 	Ended  *bool
 	URL    string
 	Source string
+	// Year is the year the show premiered, zero when unknown.
+	Year int
+	// Anime is set for Japanese animation, whose releases are numbered
+	// absolutely.
+	Anime bool
+	// Daily is set for talk shows and news, whose releases are named after
+	// their air dates.
+	Daily bool
 }
 
 // DisplayTitle implementes the Match interface
@@ -54,8 +67,33 @@ type Episode struct {
 	Title   string    `json:"title"`
 	Episode int       `json:"episode"`
 	AirDate time.Time `json:"air_date"`
+	// Runtime is in minutes, zero when unknown.
+	Runtime int `json:"runtime"`
+	// Absolute is the number of the episode counting from the first episode
+	// of the show, zero when unknown.
+	Absolute int `json:"absolute"`
 }
 
+// statusSource is implemented by sources which can tell whether a show has
+// ended.
+type statusSource interface {
+	Ended(context.Context, *store.Show) (bool, error)
+}
+
+// titleSource is a source which knows the other titles of a show.
+type titleSource interface {
+	AlternateTitles(context.Context, *store.Show) ([]string, error)
+}
+
+// searchTimeout is the time sources get to answer a search.
+var searchTimeout = 5 * time.Second
+
+var sourceLatency = metrics.NewHistogram(
+	"getme_source_request_duration_seconds",
+	"Time a source took to answer a search or to return the seasons of a show.",
+	[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	"source", "call")
+
 // sources contains all sources one can query for show information
 var sources = map[string]Source{
 	Trakt{}.Name(): Trakt{},
@@ -63,6 +101,13 @@ var sources = map[string]Source{
 	TvMaze{}.Name(): TvMaze{},
 }
 
+// IsAvailable tells whether a source, by name, can still be queried. Shows
+// might be persisted with a source which has since been removed.
+func IsAvailable(name string) bool {
+	_, ok := sources[name]
+	return ok
+}
+
 func SourceNames() (names []string) {
 	for k := range sources {
 		names = append(names, k)
@@ -71,21 +116,26 @@ func SourceNames() (names []string) {
 }
 
 // UpdateSeasonsAndEpisodes should be called to update a Show after, for
-// example, deserialization from disk.
-func UpdateSeasonsAndEpisodes(show *store.Show) error {
-	var uptodateSeasons []Season
-	var err error
-
+// example, deserialization from disk. The seasons are fetched from every
+// source the show is linked to, see Reconcile.
+func UpdateSeasonsAndEpisodes(ctx context.Context, show *store.Show) error {
 	log.WithFields(log.Fields{
 		"show":   show.Title,
 		"source": show.SourceName,
 	}).Info("Updating show.")
 
-	uptodateSeasons, err = sources[show.SourceName].Seasons(show)
+	uptodateSeasons, discrepancies, err := Reconcile(ctx, show)
 	if err != nil {
 		return err
 	}
 
+	for _, discrepancy := range discrepancies {
+		log.WithFields(log.Fields{
+			"show":        show.Title,
+			"discrepancy": discrepancy.String(),
+		}).Warn("Sources disagree.")
+	}
+
 	for i := 0; i < len(uptodateSeasons); i++ {
 		season := uptodateSeasons[i]
 		existingSeason := findExistingSeason(show.Seasons, season)
@@ -96,24 +146,114 @@ func UpdateSeasonsAndEpisodes(show *store.Show) error {
 		}
 	}
 
+	updateEnded(ctx, show)
+	updateSourceTitles(ctx, show)
+
 	return nil
 }
 
+// updateEnded asks the first linked source which knows about it whether the
+// show has ended. Only ending a show is recorded, a show which isn't known to
+// have ended keeps Ended as is.
+func updateEnded(ctx context.Context, show *store.Show) {
+	if show.Ended != nil && *show.Ended {
+		return
+	}
+
+	for _, name := range linkedSources(show) {
+		source, ok := sources[name].(statusSource)
+		if !ok {
+			continue
+		}
+
+		ID, _ := show.IDFor(name)
+		linked := *show
+		linked.ID = ID
+
+		ended, err := source.Ended(ctx, &linked)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"show":   show.Title,
+				"source": name,
+			}).Warn("Source failed to return the status of the show.")
+			return
+		}
+		if !ended {
+			return
+		}
+
+		show.Ended = &ended
+		log.WithFields(log.Fields{
+			"show":   show.Title,
+			"source": name,
+		}).Info("Show ended.")
+		notify.Send(ctx, notify.Event{
+			Type:    notify.ShowEnded,
+			Show:    show.Title,
+			Source:  name,
+			Message: show.Title + " has ended.",
+		})
+		return
+	}
+}
+
+// updateSourceTitles asks the linked sources which know them for the other
+// titles of the show. The titles are kept as they are when a source fails.
+func updateSourceTitles(ctx context.Context, show *store.Show) {
+	var titles []string
+	var asked bool
+	for _, name := range linkedSources(show) {
+		source, ok := sources[name].(titleSource)
+		if !ok {
+			continue
+		}
+
+		ID, _ := show.IDFor(name)
+		linked := *show
+		linked.ID = ID
+
+		found, err := source.AlternateTitles(ctx, &linked)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"show":   show.Title,
+				"source": name,
+			}).Warn("Source failed to return the alternate titles of the show.")
+			return
+		}
+		asked = true
+		titles = append(titles, found...)
+	}
+	if asked {
+		show.SourceTitles = titles
+	}
+}
+
 // Search is the important function of this package. Call this to turn a user
 // search string into a list of matches (which might be TV shows or movies).
-func Search(q string) []SearchResult {
-	c := make(chan SearchResult)
+// Sources which don't answer in time are cancelled.
+func Search(ctx context.Context, q string) []SearchResult {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
+	// Buffered so sources answering after the timeout don't block forever.
+	c := make(chan SearchResult, len(sources))
 	for _, source := range sources {
-		go func(s Source) { c <- s.Search(q) }(source)
+		go func(s Source) {
+			start := time.Now()
+			result := s.Search(ctx, q)
+			sourceLatency.Observe(time.Since(start).Seconds(), s.Name(), "search")
+			c <- result
+		}(source)
 	}
 
 	var searchResults []SearchResult
-	timeout := time.After(5 * time.Second)
 	for i := 0; i < len(sources); i++ {
 		select {
 		case result := <-c:
 			searchResults = append(searchResults, result)
-		case <-timeout:
+		case <-ctx.Done():
 			log.WithFields(log.Fields{
 				"successful":   len(searchResults),
 				"unsuccessful": len(sources) - len(searchResults),
@@ -131,10 +271,12 @@ func addSeason(show *store.Show, season Season) {
 	}
 	for _, episode := range season.Episodes {
 		newEpisode := store.Episode{
-			Episode: episode.Episode,
-			AirDate: episode.AirDate,
-			Title:   episode.Title,
-			Pending: true,
+			Episode:  episode.Episode,
+			AirDate:  episode.AirDate,
+			Title:    episode.Title,
+			Runtime:  episode.Runtime,
+			Absolute: episode.Absolute,
+			Pending:  true,
 		}
 		newSeason.Episodes = append(newSeason.Episodes, &newEpisode)
 	}
@@ -151,6 +293,22 @@ func updateEpisodes(existingSeason *store.Season, newSeason Season) {
 		}
 	}
 
+	// Runtimes and absolute numbers are filled in for the episodes stored
+	// before they were known.
+	for _, existing := range existingSeason.Episodes {
+		for _, episode := range newSeason.Episodes {
+			if existing.Episode != episode.Episode {
+				continue
+			}
+			if existing.Runtime == 0 {
+				existing.Runtime = episode.Runtime
+			}
+			if existing.Absolute == 0 {
+				existing.Absolute = episode.Absolute
+			}
+		}
+	}
+
 	if len(existingSeason.Episodes) == len(newSeason.Episodes) {
 		return
 	}
@@ -158,16 +316,46 @@ func updateEpisodes(existingSeason *store.Season, newSeason Season) {
 	for _, episode := range newSeason.Episodes {
 		if !contains(existingSeason.Episodes, episode) {
 			newEpisode := store.Episode{
-				Episode: episode.Episode,
-				AirDate: episode.AirDate,
-				Title:   episode.Title,
-				Pending: true,
+				Episode:  episode.Episode,
+				AirDate:  episode.AirDate,
+				Title:    episode.Title,
+				Runtime:  episode.Runtime,
+				Absolute: episode.Absolute,
+				Pending:  true,
 			}
 			existingSeason.Episodes = append(existingSeason.Episodes, &newEpisode)
 		}
 	}
 }
 
+// numberAbsolutely numbers the episodes of the seasons one after the other,
+// the way anime releases are numbered. Specials, in season 0, aren't
+// numbered.
+func numberAbsolutely(seasons []Season) {
+	var episodes []*Episode
+	seasonOf := map[*Episode]int{}
+	for i := range seasons {
+		if seasons[i].Season == 0 {
+			continue
+		}
+		for j := range seasons[i].Episodes {
+			episode := &seasons[i].Episodes[j]
+			episodes = append(episodes, episode)
+			seasonOf[episode] = seasons[i].Season
+		}
+	}
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if seasonOf[episodes[i]] != seasonOf[episodes[j]] {
+			return seasonOf[episodes[i]] < seasonOf[episodes[j]]
+		}
+		return episodes[i].Episode < episodes[j].Episode
+	})
+	for i, episode := range episodes {
+		episode.Absolute = i + 1
+	}
+}
+
 func contains(episodes []*store.Episode, other Episode) bool {
 	for _, e := range episodes {
 		if e.Episode == other.Episode {
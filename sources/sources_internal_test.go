@@ -1,5 +1,49 @@
 package sources
 
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowSource answers when its search is cancelled.
+type slowSource struct {
+	cancelled chan bool
+}
+
+func (s slowSource) Name() string { return "slow" }
+
+func (s slowSource) Search(ctx context.Context, q string) SearchResult {
+	<-ctx.Done()
+	s.cancelled <- true
+	return SearchResult{Name: s.Name(), Error: ctx.Err()}
+}
+
+func (s slowSource) Seasons(ctx context.Context, show *store.Show) ([]Season, error) {
+	return nil, nil
+}
+
+func TestSearchCancelsSlowSources(t *testing.T) {
+	slow := slowSource{cancelled: make(chan bool, 1)}
+	defer withSources(slow, fakeSource{name: tvMazeName})()
+
+	original := searchTimeout
+	searchTimeout = 10 * time.Millisecond
+	defer func() { searchTimeout = original }()
+
+	results := Search(context.Background(), "query")
+	assert.Equal(t, 1, len(results))
+
+	select {
+	case <-slow.cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow source to be cancelled")
+	}
+}
+
 //func TestUpdateSeasonsAndEpisodes(t *testing.T) {
 //stack := []string{
 //"testdata/updated_seasons.json", // Fixture contains 1 new episode in season 2 and 1 new season.
@@ -38,3 +82,18 @@ package sources
 //t.Error("Expected 7 episodes (3 new), got:", len(s.Episodes()))
 //}
 //}
+
+func TestNumberAbsolutely(t *testing.T) {
+	seasons := []Season{
+		{Season: 2, Episodes: []Episode{{Episode: 2}, {Episode: 1}}},
+		{Season: 0, Episodes: []Episode{{Episode: 1}}},
+		{Season: 1, Episodes: []Episode{{Episode: 1}, {Episode: 2}, {Episode: 3}}},
+	}
+	numberAbsolutely(seasons)
+
+	assert.Equal(t, 5, seasons[0].Episodes[0].Absolute)
+	assert.Equal(t, 4, seasons[0].Episodes[1].Absolute)
+	assert.Equal(t, 0, seasons[1].Episodes[0].Absolute, "specials aren't numbered")
+	assert.Equal(t, 1, seasons[2].Episodes[0].Absolute)
+	assert.Equal(t, 3, seasons[2].Episodes[2].Absolute)
+}
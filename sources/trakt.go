@@ -1,9 +1,11 @@
 package sources
 
 import (
+	"context"
 	"time"
 
 	"github.com/42minutes/go-trakt"
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/store"
 )
 
@@ -17,10 +19,10 @@ func (t Trakt) Name() string {
 	return traktName
 }
 
-func (t Trakt) Seasons(show *store.Show) ([]Season, error) {
+func (t Trakt) Seasons(ctx context.Context, show *store.Show) ([]Season, error) {
 	var seasons []Season
 
-	client := traktClient()
+	client := traktClient(ctx)
 	traktSeasons, result := client.Seasons().All(show.ID)
 	if result.Err != nil {
 		return seasons, result.Err
@@ -49,15 +51,16 @@ func (t Trakt) Seasons(show *store.Show) ([]Season, error) {
 		}
 		seasons = append(seasons, season)
 	}
+	numberAbsolutely(seasons)
 	return seasons, nil
 }
 
-func (t Trakt) Search(q string) SearchResult {
+func (t Trakt) Search(ctx context.Context, q string) SearchResult {
 	searchResult := SearchResult{
 		Name: traktName,
 	}
 
-	results, response := traktClient().Shows().Search(q)
+	results, response := traktClient(ctx).Shows().Search(q)
 	if response.Err != nil {
 		searchResult.Error = response.Err
 		return searchResult
@@ -73,6 +76,7 @@ func (t Trakt) Search(q string) SearchResult {
 				Ended:  &ended,
 				URL:    traktURL + "shows/" + result.Show.IDs.Slug,
 				Source: searchResult.Name,
+				Year:   result.Show.Year,
 			},
 		)
 	}
@@ -84,7 +88,7 @@ func (t Trakt) isEnded(status string) bool {
 	return status == "ended"
 }
 
-func traktClient() *trakt.Client {
+func traktClient(ctx context.Context) *trakt.Client {
 	apiKey := "01045164ed603042b53acf841b590f0e7b728dbff319c8d128f8649e2427cbe9"
 	authMethod := trakt.TokenAuth{AccessToken: "3b6f5bdba2fa56b086712d5f3f15b4e967f99ab049a6d3a4c2e56dc9c3c90462"}
 
@@ -93,6 +97,6 @@ func traktClient() *trakt.Client {
 		trakt.UserAgent,
 		apiKey,
 		authMethod,
-		nil,
+		request.HTTPClientFor(ctx),
 	)
 }
@@ -1,9 +1,11 @@
 package sources
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
+	"unicode"
 
 	"github.com/haarts/getme/store"
 )
@@ -18,12 +20,13 @@ func (t TvMaze) Name() string {
 	return tvMazeName
 }
 
-func (t TvMaze) Search(q string) SearchResult {
+func (t TvMaze) Search(ctx context.Context, q string) SearchResult {
 	searchResult := SearchResult{
 		Name: tvMazeName,
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		fmt.Sprintf(tvMazeURL+"/search/shows?q=%s", q),
 		nil)
@@ -50,6 +53,9 @@ func (t TvMaze) Search(q string) SearchResult {
 				Ended:  &ended,
 				URL:    r.Show.URL,
 				Source: tvMazeName,
+				Year:   r.Show.year(),
+				Anime:  r.Show.isAnime(),
+				Daily:  r.Show.isDaily(),
 			})
 	}
 
@@ -61,8 +67,29 @@ func (t TvMaze) isEnded(status string) bool {
 	return status == "Ended"
 }
 
-func (t TvMaze) Seasons(show *store.Show) ([]Season, error) {
-	req, err := http.NewRequest(
+// Ended tells whether the show has ended.
+func (t TvMaze) Ended(ctx context.Context, show *store.Show) (bool, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		fmt.Sprintf(tvMazeURL+"/shows/%d", show.ID),
+		nil)
+	if err != nil {
+		return false, err
+	}
+
+	result := &tvMazeShow{}
+	err = GetJSON(req, result)
+	if err != nil {
+		return false, err
+	}
+
+	return t.isEnded(result.Status), nil
+}
+
+func (t TvMaze) Seasons(ctx context.Context, show *store.Show) ([]Season, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		fmt.Sprintf(tvMazeURL+"/shows/%d/episodes", show.ID),
 		nil)
@@ -86,7 +113,7 @@ func (t TvMaze) Seasons(show *store.Show) ([]Season, error) {
 		}
 		season.Episodes = append(
 			season.Episodes,
-			Episode{Title: r.Name, Episode: r.Number, AirDate: *r.Airdate},
+			Episode{Title: r.Name, Episode: r.Number, AirDate: *r.Airdate, Runtime: r.Runtime},
 		)
 	}
 
@@ -94,20 +121,86 @@ func (t TvMaze) Seasons(show *store.Show) ([]Season, error) {
 	for _, v := range seasons {
 		s = append(s, *v)
 	}
+	numberAbsolutely(s)
 
 	return s, nil
 }
 
+// AlternateTitles returns the titles the show is also known by. Titles in
+// other scripts are left out, releases are named in Latin script.
+func (t TvMaze) AlternateTitles(ctx context.Context, show *store.Show) ([]string, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		fmt.Sprintf(tvMazeURL+"/shows/%d/akas", show.ID),
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &[]tvMazeAKA{}
+	err = GetJSON(req, result)
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	for _, aka := range *result {
+		if aka.Name != "" && aka.Name != show.Title && isASCII(aka.Name) {
+			titles = append(titles, aka.Name)
+		}
+	}
+	return titles, nil
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+type tvMazeAKA struct {
+	Name string `json:"name"`
+}
+
 type tvMazeResult struct {
 	Score float64    `json:"score"`
 	Show  tvMazeShow `json:"show"`
 }
 
 type tvMazeShow struct {
-	Title  string `json:"name"`
-	ID     int    `json:"id"`
-	Status string `json:"status"`
-	URL    string `json:"url"`
+	Title     string   `json:"name"`
+	ID        int      `json:"id"`
+	Status    string   `json:"status"`
+	URL       string   `json:"url"`
+	Premiered string   `json:"premiered"`
+	Genres    []string `json:"genres"`
+	Type      string   `json:"type"`
+}
+
+// isDaily tells whether the show is of a kind which airs daily.
+func (s tvMazeShow) isDaily() bool {
+	return s.Type == "Talk Show" || s.Type == "News"
+}
+
+// isAnime tells whether the show is Japanese animation.
+func (s tvMazeShow) isAnime() bool {
+	for _, genre := range s.Genres {
+		if genre == "Anime" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s tvMazeShow) year() int {
+	premiered, err := time.Parse("2006-01-02", s.Premiered)
+	if err != nil {
+		return 0
+	}
+	return premiered.Year()
 }
 
 type tvMazeEpisode struct {
@@ -115,4 +208,5 @@ type tvMazeEpisode struct {
 	Season  int        `json:"season"`
 	Number  int        `json:"number"`
 	Airdate *time.Time `json:"airstamp"`
+	Runtime int        `json:"runtime"`
 }
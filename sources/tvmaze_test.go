@@ -1,12 +1,14 @@
 package sources_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/haarts/getme/sources"
 	"github.com/haarts/getme/store"
@@ -29,11 +31,12 @@ func TestTvMazeSearch(t *testing.T) {
 
 	sources.SetTvMazeURL(ts.URL)
 
-	results := (sources.TvMaze{}).Search("query")
+	results := (sources.TvMaze{}).Search(context.Background(), "query")
 	require.NoError(t, results.Error)
 	require.Len(t, results.Shows, 10)
 	assert.Equal(t, "Dead Set", results.Shows[0].Title)
 	assert.True(t, *results.Shows[0].Ended)
+	assert.Equal(t, 2008, results.Shows[0].Year)
 }
 
 func TestTvMazeSeasons(t *testing.T) {
@@ -52,7 +55,7 @@ func TestTvMazeSeasons(t *testing.T) {
 
 	sources.SetTvMazeURL(ts.URL)
 
-	seasons, err := (sources.TvMaze{}).Seasons(&store.Show{ID: 1})
+	seasons, err := (sources.TvMaze{}).Seasons(context.Background(), &store.Show{ID: 1})
 	require.NoError(t, err)
 	require.Len(t, seasons, 3)
 	var season1 sources.Season
@@ -64,6 +67,44 @@ func TestTvMazeSeasons(t *testing.T) {
 	assert.Len(t, season1.Episodes, 13)
 }
 
+func TestTvMazeEnded(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/shows/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"id": 1, "name": "Dead Set", "status": "Ended"}`)
+	})
+
+	sources.SetTvMazeURL(ts.URL)
+
+	ended, err := (sources.TvMaze{}).Ended(context.Background(), &store.Show{ID: 1})
+	require.NoError(t, err)
+	assert.True(t, ended)
+}
+
+func TestTvMazeAlternateTitles(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mux.HandleFunc("/shows/1/akas", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `[
+			{"name": "Agents of S.H.I.E.L.D.", "country": null},
+			{"name": "Агенты «Щ.И.Т.»", "country": {"code": "RU"}},
+			{"name": "Marvel's Agents of S.H.I.E.L.D.", "country": {"code": "US"}}
+		]`)
+	})
+
+	sources.SetTvMazeURL(ts.URL)
+
+	titles, err := (sources.TvMaze{}).AlternateTitles(context.Background(), &store.Show{ID: 1, Title: "Marvel's Agents of S.H.I.E.L.D."})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Agents of S.H.I.E.L.D."}, titles)
+}
+
 func readFixture(file string) string {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -72,3 +113,28 @@ func readFixture(file string) string {
 	}
 	return string(data)
 }
+
+func TestTvMazeChangesSince(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	since := time.Now().Add(-2 * time.Hour)
+	mux.HandleFunc("/updates/shows", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "day", r.URL.Query().Get("since"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"1": %d, "2": %d}`, since.Add(time.Hour).Unix(), since.Add(-time.Hour).Unix())
+	})
+
+	sources.SetTvMazeURL(ts.URL)
+	changes, err := sources.ChangesSince(context.Background(), since)
+	require.NoError(t, err)
+
+	assert.True(t, changes.Changed(&store.Show{SourceName: "tvmaze", ID: 1}))
+	assert.False(t, changes.Changed(&store.Show{SourceName: "tvmaze", ID: 2}))
+	assert.False(t, changes.Changed(&store.Show{SourceName: "tvmaze", ID: 3}), "shows missing from the index didn't change")
+	assert.True(t, changes.Changed(&store.Show{SourceName: "trakt", ID: 2}), "shows not on TvMaze are refreshed")
+
+	var nothing *sources.Changes
+	assert.True(t, nothing.Changed(&store.Show{SourceName: "tvmaze", ID: 2}))
+}
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net/http"
@@ -56,12 +57,12 @@ func (t TvRage) Name() string {
 }
 
 // Search returns matches found by this source based on the query.
-func (t TvRage) Search(query string) SearchResult {
+func (t TvRage) Search(ctx context.Context, query string) SearchResult {
 	searchResult := SearchResult{
 		Name: tvRageName,
 	}
 
-	req, err := tvRageRequest(constructTvRageSearchURL(query))
+	req, err := tvRageRequest(ctx, constructTvRageSearchURL(query))
 
 	if err != nil {
 		searchResult.Error = err
@@ -84,8 +85,8 @@ func (t TvRage) Search(query string) SearchResult {
 }
 
 // Seasons finds the seasons and episodes for a show with this source.
-func (t TvRage) Seasons(show *store.Show) ([]Season, error) {
-	req, err := tvRageRequest(constructTvRageSeasonsURL(show.ID))
+func (t TvRage) Seasons(ctx context.Context, show *store.Show) ([]Season, error) {
+	req, err := tvRageRequest(ctx, constructTvRageSeasonsURL(show.ID))
 	if err != nil {
 		return nil, err
 	}
@@ -99,8 +100,8 @@ func (t TvRage) Seasons(show *store.Show) ([]Season, error) {
 	return convertFromTvRageSeasons(result.EpisodeList.Seasons), nil
 }
 
-func tvRageRequest(URL string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", URL, nil)
+func tvRageRequest(ctx context.Context, URL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
 	if err != nil {
 		return nil, err
 	}
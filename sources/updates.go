@@ -0,0 +1,88 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/haarts/getme/store"
+)
+
+// Changes tells which shows changed on their sources since a moment in time.
+// Only TvMaze publishes an index of changes, shows not linked to TvMaze are
+// always considered changed. The index only lists the shows which changed in
+// its period, shows missing from it haven't changed. A nil *Changes considers
+// every show changed.
+type Changes struct {
+	since   time.Time
+	updated map[int]time.Time
+}
+
+// ChangesSince fetches the index of changed shows from TvMaze.
+func ChangesSince(ctx context.Context, since time.Time) (*Changes, error) {
+	updated, err := TvMaze{}.updates(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Changes{
+		since:   since,
+		updated: updated,
+	}, nil
+}
+
+// Changed reports whether a show should be refreshed from its sources.
+func (c *Changes) Changed(show *store.Show) bool {
+	if c == nil {
+		return true
+	}
+
+	ID, ok := show.IDFor(tvMazeName)
+	if !ok {
+		return true
+	}
+
+	updated, ok := c.updated[ID]
+	if !ok {
+		return false
+	}
+	return updated.After(c.since)
+}
+
+// updates returns, per TvMaze show ID, when the show last changed. The index
+// is limited to the smallest period covering since which TvMaze offers.
+func (t TvMaze) updates(ctx context.Context, since time.Time) (map[int]time.Time, error) {
+	URL := tvMazeURL + "/updates/shows"
+	switch age := time.Since(since); {
+	case age < 24*time.Hour:
+		URL += "?since=day"
+	case age < 7*24*time.Hour:
+		URL += "?since=week"
+	case age < 30*24*time.Hour:
+		URL += "?since=month"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// An outdated index makes us miss changes. Always check with TvMaze.
+	req.Header.Set("Cache-Control", "no-cache")
+
+	result := map[string]int64{}
+	err = GetJSON(req, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := make(map[int]time.Time, len(result))
+	for k, v := range result {
+		var ID int
+		if _, err := fmt.Sscanf(k, "%d", &ID); err != nil {
+			continue
+		}
+		updated[ID] = time.Unix(v, 0)
+	}
+	return updated, nil
+}
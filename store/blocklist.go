@@ -0,0 +1,197 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blocked is the history action of blocking a snatched torrent.
+const Blocked = "blocked"
+
+// ErrNotSnatched is returned when marking a torrent bad which wasn't
+// downloaded.
+var ErrNotSnatched = errors.New("nothing was downloaded")
+
+// BlockedTorrent is a torrent which turned out to be fake or dead and is
+// never downloaded again.
+type BlockedTorrent struct {
+	InfoHash string    `json:"info_hash,omitempty"`
+	Title    string    `json:"title"`
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+}
+
+type blocklist struct {
+	sync.RWMutex
+	torrents []BlockedTorrent
+}
+
+const blocklistFile = "blocklist.json"
+
+// Block adds a torrent to the blocklist and writes the blocklist to disk
+// straight away.
+func (s Store) Block(torrent BlockedTorrent) error {
+	s.blocklist.Lock()
+	defer s.blocklist.Unlock()
+
+	s.blocklist.torrents = append(s.blocklist.torrents, torrent)
+	b, err := json.MarshalIndent(s.blocklist.torrents, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(s.stateDir, blocklistFile), b, 0644)
+}
+
+// IsBlocked tells whether a torrent is on the blocklist, by info hash or, when
+// either doesn't know its info hash, by title. Fakes copy the titles of real
+// releases, so a fake never blocks a torrent with another info hash.
+func (s Store) IsBlocked(infoHash, title string) bool {
+	if s.blocklist == nil {
+		return false
+	}
+	s.blocklist.RLock()
+	defer s.blocklist.RUnlock()
+
+	for _, blocked := range s.blocklist.torrents {
+		if infoHash != "" && blocked.InfoHash != "" {
+			if strings.EqualFold(blocked.InfoHash, infoHash) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(blocked.Title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocklist returns the blocked torrents, the oldest first.
+func (s Store) Blocklist() []BlockedTorrent {
+	s.blocklist.RLock()
+	defer s.blocklist.RUnlock()
+	return append([]BlockedTorrent(nil), s.blocklist.torrents...)
+}
+
+func (s *Store) deserializeBlocklist() error {
+	d, err := ioutil.ReadFile(path.Join(s.stateDir, blocklistFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(d, &s.blocklist.torrents)
+}
+
+// MarkBad blocks the torrent last snatched for an episode of the show, or
+// for a whole season when episode is 0, and wants every episode snatched with
+// it again, like the other episodes of a pack.
+func (s *Store) MarkBad(show *Show, season, episode int, reason string, at time.Time) (HistoryEntry, error) {
+	snatched, err := s.History(HistoryFilter{Show: show.Title})
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	for i := len(snatched) - 1; i >= 0; i-- {
+		entry := snatched[i]
+		if entry.Action == Snatched && entry.Season == season && entry.Episode == episode {
+			return entry, s.markBad(show, entry, reason, at)
+		}
+	}
+	return HistoryEntry{}, fmt.Errorf("%w for season %d episode %d of %s", ErrNotSnatched, season, episode, show.Title)
+}
+
+// DownloadFailed blocks the torrent, by info hash or by title, the download
+// client failed to download and wants every episode snatched with it again.
+func (s *Store) DownloadFailed(infoHash, title, reason string, at time.Time) (HistoryEntry, error) {
+	snatched, err := s.History(HistoryFilter{})
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	for i := len(snatched) - 1; i >= 0; i-- {
+		entry := snatched[i]
+		if entry.Action != Snatched {
+			continue
+		}
+		if (infoHash != "" && strings.EqualFold(entry.InfoHash, infoHash)) || (title != "" && entry.Torrent == title) {
+			show, ok := s.shows[entry.Show]
+			if !ok {
+				return entry, fmt.Errorf("show %s doesn't exist", entry.Show)
+			}
+			return entry, s.markBad(show, entry, reason, at)
+		}
+	}
+	name := infoHash
+	if name == "" {
+		name = title
+	}
+	return HistoryEntry{}, fmt.Errorf("%w as torrent %s", ErrNotSnatched, name)
+}
+
+// sameTorrent tells whether two entries are about the same torrent, by info
+// hash when both know it and by title otherwise.
+func sameTorrent(a, b HistoryEntry) bool {
+	if a.InfoHash != "" && b.InfoHash != "" {
+		return strings.EqualFold(a.InfoHash, b.InfoHash)
+	}
+	return a.Torrent == b.Torrent
+}
+
+// want wants an episode of the show again, or a whole season when episode
+// is 0.
+func (s *Show) want(season, episode int) {
+	for _, se := range s.Seasons {
+		if se.Season != season {
+			continue
+		}
+		for _, e := range se.Episodes {
+			if episode == 0 || e.Episode == episode {
+				e.Pending = true
+				e.TriedAt = time.Time{}
+				e.Backoff = 0
+			}
+		}
+	}
+}
+
+func (s *Store) markBad(show *Show, snatched HistoryEntry, reason string, at time.Time) error {
+	entries, err := s.History(HistoryFilter{Show: show.Title})
+	if err != nil {
+		return err
+	}
+
+	err = s.Block(BlockedTorrent{
+		InfoHash: snatched.InfoHash,
+		Title:    snatched.Torrent,
+		Reason:   reason,
+		At:       at,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Action == Snatched && sameTorrent(entry, snatched) {
+			show.want(entry.Season, entry.Episode)
+		}
+	}
+	if err := s.Save(show); err != nil {
+		return err
+	}
+
+	blocked := snatched
+	blocked.At = at
+	blocked.Action = Blocked
+	blocked.Reason = reason
+	return s.AppendHistory(blocked)
+}
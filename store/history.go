@@ -0,0 +1,138 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The actions recorded in the history.
+const (
+	// Rejected torrents didn't pass a filter.
+	Rejected = "rejected"
+	// Vetoed torrents were turned down by the pre snatch hook.
+	Vetoed = "vetoed"
+	// Outranked torrents lost to a better torrent.
+	Outranked = "outranked"
+	// Selected torrents were the best found for a season or episode.
+	Selected = "selected"
+	// Snatched torrents were downloaded and completed their season or
+	// episode.
+	Snatched = "snatched"
+	// Failed torrents couldn't be downloaded.
+	Failed = "failed"
+)
+
+// HistoryEntry records a decision made about a torrent.
+type HistoryEntry struct {
+	At     time.Time `json:"at"`
+	Action string    `json:"action"`
+	Show   string    `json:"show"`
+	Season int       `json:"season"`
+	// Episode is 0 when the torrent is for the whole season.
+	Episode  int    `json:"episode"`
+	Engine   string `json:"engine,omitempty"`
+	Query    string `json:"query,omitempty"`
+	Torrent  string `json:"torrent"`
+	Seeds    int    `json:"seeds"`
+	URL      string `json:"url"`
+	InfoHash string `json:"info_hash,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// HistoryFilter selects history entries. Zero fields match every entry.
+type HistoryFilter struct {
+	// Show and Engine are matched case insensitively.
+	Show   string
+	Engine string
+	// Action, like Snatched, is matched exactly.
+	Action string
+	// Since and Until bound the time of the entries, Until is exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+func (f HistoryFilter) matches(entry HistoryEntry) bool {
+	if f.Show != "" && !strings.EqualFold(f.Show, entry.Show) {
+		return false
+	}
+	if f.Engine != "" && !strings.EqualFold(f.Engine, entry.Engine) {
+		return false
+	}
+	if f.Action != "" && f.Action != entry.Action {
+		return false
+	}
+	if !f.Since.IsZero() && entry.At.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.At.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+const historyFile = "history.jsonl"
+
+// AppendHistory adds entries to the history. The history is only ever
+// appended to, one JSON object per line.
+func (s Store) AppendHistory(entries ...HistoryEntry) error {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	file, err := os.OpenFile(path.Join(s.stateDir, historyFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// History returns the entries matching the filter, the oldest first.
+func (s Store) History(filter HistoryFilter) ([]HistoryEntry, error) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	file, err := os.Open(path.Join(s.stateDir, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"line": scanner.Text(),
+			}).Warn("Skipping unreadable history entry.")
+			continue
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
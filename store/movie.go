@@ -2,7 +2,13 @@ package store
 
 // Movie contains all the relevant information for a movie.
 type Movie struct {
-	Title string
+	Title   string `json:"title"`
+	Pending bool   `json:"pending"`
+}
+
+// Done marks the movie as downloaded.
+func (m *Movie) Done() {
+	m.Pending = false
 }
 
 // DisplayTitle returns the title of a movie. Here to satisfy the Match
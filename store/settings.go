@@ -0,0 +1,52 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Set changes a setting of the show by name, like 'anime', 'daily',
+// 'specials' or 'alternate_titles', as given on the command line or through
+// the API. Alternate titles are separated by semicolons, an empty value
+// removes them.
+func (s *Show) Set(setting, value string) error {
+	switch setting {
+	case "anime":
+		anime, err := parseSetting(setting, value)
+		if err != nil {
+			return err
+		}
+		s.Anime = anime
+	case "daily":
+		daily, err := parseSetting(setting, value)
+		if err != nil {
+			return err
+		}
+		s.Daily = &daily
+	case "specials":
+		specials, err := parseSetting(setting, value)
+		if err != nil {
+			return err
+		}
+		s.Specials = specials
+	case "alternate_titles":
+		s.AlternateTitles = nil
+		for _, title := range strings.Split(value, ";") {
+			if title = strings.TrimSpace(title); title != "" {
+				s.AlternateTitles = append(s.AlternateTitles, title)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown setting %s", setting)
+	}
+	return nil
+}
+
+func parseSetting(setting, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s should be true or false, not %s", setting, value)
+	}
+	return b, nil
+}
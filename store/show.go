@@ -2,34 +2,268 @@ package store
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 )
 
 // Show contains all the relevant information for a TV show. A value is Show is
 // the main way on interfacing with the show, seasons AND episodes.
 type Show struct {
-	Title         string        `json:"title"`
-	URL           string        `json:"url"`
-	ID            int           `json:"id"`
-	Ended         *bool         `json:"ended"`
-	Seasons       []*Season     `json:"seasons"`
-	SourceName    string        `json:"source_name"`
-	QuerySnippets QuerySnippets `json:"query_snippets"`
+	Title         string         `json:"title"`
+	URL           string         `json:"url"`
+	ID            int            `json:"id"`
+	Ended         *bool          `json:"ended"`
+	Seasons       []*Season      `json:"seasons"`
+	SourceName    string         `json:"source_name"`
+	ExternalIDs   map[string]int `json:"external_ids"`
+	QuerySnippets QuerySnippets  `json:"query_snippets"`
+	// RefreshedAt is when the daemon last refreshed the show from its
+	// sources.
+	RefreshedAt time.Time `json:"refreshed_at"`
+	// RefreshInterval overrides how often the daemon refreshes this show,
+	// like "72h". Empty means the configured refresh_interval.
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+	// Paused shows are neither refreshed nor searched for.
+	Paused bool `json:"paused"`
+	// Anime shows are also searched for by the absolute numbers of their
+	// episodes, the way fansub groups name their releases.
+	Anime bool `json:"anime,omitempty"`
+	// Daily shows, like talk shows, are searched for by air date only.
+	// Nil when it isn't set, see IsDaily.
+	Daily *bool `json:"daily,omitempty"`
+	// Specials, the episodes of season 0, are only searched for when set.
+	Specials bool `json:"specials,omitempty"`
+	// AlternateTitles are other titles the show is released under, like
+	// 'Agents of SHIELD', as set by the user.
+	AlternateTitles []string `json:"alternate_titles,omitempty"`
+	// SourceTitles are the other titles the sources know the show by.
+	SourceTitles []string `json:"source_titles,omitempty"`
 }
 
-// QuerySnippets is a collection of Snippets for episodes and seasons.
+// IsDaily tells whether the show airs daily, as set or, when it isn't set,
+// as its episodes tell.
+func (s *Show) IsDaily() bool {
+	if s.Daily != nil {
+		return *s.Daily
+	}
+	return s.airsDaily()
+}
+
+const (
+	// dailyEpisodes is the number of air dates needed to tell whether a
+	// show airs daily.
+	dailyEpisodes = 5
+	// dailySpan is how long a show has to be airing to tell whether it
+	// airs daily.
+	dailySpan = 21 * 24 * time.Hour
+)
+
+// airsDaily tells whether the episodes of a show air a day or two apart, as
+// those of talk shows and news do. Episodes released on the same day, like
+// a season released at once, don't count.
+func (s *Show) airsDaily() bool {
+	days := map[time.Time]bool{}
+	for _, season := range s.Seasons {
+		if season.Season == 0 {
+			continue
+		}
+		for _, episode := range season.Episodes {
+			if !episode.AirDate.IsZero() {
+				y, m, d := episode.AirDate.Date()
+				days[time.Date(y, m, d, 0, 0, 0, 0, time.UTC)] = true
+			}
+		}
+	}
+	if len(days) < dailyEpisodes {
+		return false
+	}
+
+	var dates []time.Time
+	for day := range days {
+		dates = append(dates, day)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	if dates[len(dates)-1].Sub(dates[0]) < dailySpan {
+		return false
+	}
+
+	var gaps []float64
+	for i := 1; i < len(dates); i++ {
+		gaps = append(gaps, dates[i].Sub(dates[i-1]).Hours()/24)
+	}
+	sort.Float64s(gaps)
+	return gaps[len(gaps)/2] <= 2
+}
+
+// Titles returns every title the show is searched for by, the title first
+// and then the alternate titles, without duplicates.
+func (s *Show) Titles() []string {
+	titles := []string{s.Title}
+	seen := map[string]bool{strings.ToLower(s.Title): true}
+	for _, title := range append(append([]string(nil), s.AlternateTitles...), s.SourceTitles...) {
+		title = strings.TrimSpace(title)
+		if title == "" || seen[strings.ToLower(title)] {
+			continue
+		}
+		seen[strings.ToLower(title)] = true
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+// Copy returns a copy of the show which shares nothing with it. Work on a copy
+// to leave the store unlocked while refreshing or searching a show, then
+// take the results with Merge.
+func (s *Show) Copy() *Show {
+	c := *s
+	if s.Ended != nil {
+		ended := *s.Ended
+		c.Ended = &ended
+	}
+	if s.Daily != nil {
+		daily := *s.Daily
+		c.Daily = &daily
+	}
+	if s.ExternalIDs != nil {
+		c.ExternalIDs = make(map[string]int, len(s.ExternalIDs))
+		for source, ID := range s.ExternalIDs {
+			c.ExternalIDs[source] = ID
+		}
+	}
+	c.AlternateTitles = append([]string(nil), s.AlternateTitles...)
+	c.SourceTitles = append([]string(nil), s.SourceTitles...)
+	c.QuerySnippets = QuerySnippets{
+		ForEpisode: append([]Snippet(nil), s.QuerySnippets.ForEpisode...),
+		ForSeason:  append([]Snippet(nil), s.QuerySnippets.ForSeason...),
+		ForSeries:  append([]Snippet(nil), s.QuerySnippets.ForSeries...),
+	}
+
+	c.Seasons = nil
+	for _, season := range s.Seasons {
+		copied := &Season{Season: season.Season}
+		for _, episode := range season.Episodes {
+			e := *episode
+			copied.Episodes = append(copied.Episodes, &e)
+		}
+		c.Seasons = append(c.Seasons, copied)
+	}
+	return &c
+}
+
+// Merge takes what a job changed on a copy of the show: base is the show as
+// it was copied and changed is the copy after the job. Only the fields the
+// job changed are taken, episode by episode, so an episode marked as
+// downloaded or wanted meanwhile keeps that unless the job changed it too.
+// The settings of the show are kept.
+func (s *Show) Merge(base, changed *Show) {
+	if changed.URL != base.URL || changed.ID != base.ID || changed.SourceName != base.SourceName {
+		s.URL = changed.URL
+		s.ID = changed.ID
+		s.SourceName = changed.SourceName
+	}
+	if !reflect.DeepEqual(changed.ExternalIDs, base.ExternalIDs) {
+		s.ExternalIDs = changed.ExternalIDs
+	}
+	if !reflect.DeepEqual(changed.Ended, base.Ended) {
+		s.Ended = changed.Ended
+	}
+	if !reflect.DeepEqual(changed.SourceTitles, base.SourceTitles) {
+		s.SourceTitles = changed.SourceTitles
+	}
+	if !reflect.DeepEqual(changed.QuerySnippets, base.QuerySnippets) {
+		s.QuerySnippets = changed.QuerySnippets
+	}
+
+	for _, season := range changed.Seasons {
+		was := base.season(season.Season)
+		current := s.season(season.Season)
+		if current == nil {
+			if was == nil {
+				s.Seasons = append(s.Seasons, season)
+			}
+			continue
+		}
+
+		for _, episode := range season.Episodes {
+			var before *Episode
+			if was != nil {
+				before = was.episode(episode.Episode)
+			}
+			live := current.episode(episode.Episode)
+			switch {
+			case live == nil && before == nil:
+				current.Episodes = append(current.Episodes, episode)
+			case live != nil && before != nil:
+				live.merge(before, episode)
+			}
+		}
+	}
+}
+
+// merge takes the fields of an episode which changed from before to after.
+func (e *Episode) merge(before, after *Episode) {
+	if after.Title != before.Title {
+		e.Title = after.Title
+	}
+	if !after.AirDate.Equal(before.AirDate) {
+		e.AirDate = after.AirDate
+	}
+	if after.Runtime != before.Runtime {
+		e.Runtime = after.Runtime
+	}
+	if after.Absolute != before.Absolute {
+		e.Absolute = after.Absolute
+	}
+	if after.Pending != before.Pending {
+		e.Pending = after.Pending
+	}
+	if !after.TriedAt.Equal(before.TriedAt) {
+		e.TriedAt = after.TriedAt
+	}
+	if after.Backoff != before.Backoff {
+		e.Backoff = after.Backoff
+	}
+}
+
+// season returns the season with the given number, nil when there is none.
+func (s *Show) season(number int) *Season {
+	for _, season := range s.Seasons {
+		if season.Season == number {
+			return season
+		}
+	}
+	return nil
+}
+
+// episode returns the episode with the given number, nil when there is none.
+func (s *Season) episode(number int) *Episode {
+	for _, episode := range s.Episodes {
+		if episode.Episode == number {
+			return episode
+		}
+	}
+	return nil
+}
+
+// QuerySnippets is a collection of Snippets for episodes, seasons and
+// complete series.
 type QuerySnippets struct {
 	ForEpisode []Snippet `json:"for_episode"`
 	ForSeason  []Snippet `json:"for_season"`
+	ForSeries  []Snippet `json:"for_series,omitempty"`
 }
 
 // Snippet contains information on how a show can be found best. The
 // TitleSnippet contains a possibly other name. The FormatSnippet contains how
-// seasons/episodes are formatted.
+// seasons/episodes are formatted. Score is the score of the torrent last
+// found with the snippet, the stats tell how well it does overall.
 type Snippet struct {
 	Score         int    `json:"score"`
 	TitleSnippet  string `json:"title_snippet"`
 	FormatSnippet string `json:"format_snippet"`
+	SnippetStats
 }
 
 // Season is _always_ part of a Show and contains meta data on a season in the show.
@@ -39,17 +273,23 @@ type Season struct {
 }
 
 // Episode is _always_ part of a Season and contains meta data on an episode in
-// the show.
-// TODO use TriedAt and Backoff to slowly stop trying to download episodes
-// which prop never complete.
+// the show. TriedAt and Backoff are used to slowly stop trying to download
+// episodes which probably never complete.
 type Episode struct {
 	Title   string    `json:"title"`
 	Episode int       `json:"episode"`
 	Pending bool      `json:"pending"`
 	AirDate time.Time `json:"air_date"`
-	season  int
-	//TriedAt time.Time
-	//Backoff int
+	// Runtime is in minutes, zero when unknown.
+	Runtime int `json:"runtime,omitempty"`
+	// Absolute is the number of the episode counting from the first
+	// episode of the show, zero when unknown.
+	Absolute int `json:"absolute,omitempty"`
+	season   int
+	// TriedAt is when a torrent was last searched for this episode.
+	TriedAt time.Time `json:"tried_at"`
+	// Backoff counts the searches which didn't complete the episode.
+	Backoff int `json:"backoff"`
 }
 
 // Sorts the youngest episode on top.
@@ -72,6 +312,44 @@ func (s *Show) String() string {
 	)
 }
 
+// IDFor returns the ID under which the show is known at a particular source.
+// The second return value is false when the show isn't linked to that source.
+func (s *Show) IDFor(sourceName string) (int, bool) {
+	if sourceName == s.SourceName {
+		return s.ID, true
+	}
+	ID, ok := s.ExternalIDs[sourceName]
+	return ID, ok
+}
+
+// LinkSource records the ID under which the show is known at another source.
+func (s *Show) LinkSource(sourceName string, ID int) {
+	if s.ExternalIDs == nil {
+		s.ExternalIDs = make(map[string]int)
+	}
+	s.ExternalIDs[sourceName] = ID
+}
+
+// FirstAired returns the air date of the first regular episode of the show.
+// The zero time is returned when it is unknown.
+func (s *Show) FirstAired() time.Time {
+	var first time.Time
+	for _, season := range s.Seasons {
+		if season.Season == 0 {
+			continue
+		}
+		for _, episode := range season.Episodes {
+			if episode.AirDate.IsZero() {
+				continue
+			}
+			if first.IsZero() || episode.AirDate.Before(first) {
+				first = episode.AirDate
+			}
+		}
+	}
+	return first
+}
+
 // DisplayTitle returns the title of a TV show. Here to satisfy the Match
 // interface.
 func (s Show) DisplayTitle() string {
@@ -94,52 +372,52 @@ func (s *Season) Done() {
 	}
 }
 
-func (s *Show) BestSeasonSnippet() Snippet {
-	var best Snippet
-	for _, snippet := range s.QuerySnippets.ForSeason {
-		if snippet.Score >= best.Score {
-			best = snippet
-		}
-	}
-	return best
+// Done flags an episode as 'downloaded' and thus done. This episode is
+// never looked up on a search engine agian.
+func (e *Episode) Done() {
+	e.Pending = false
 }
 
-func (s *Show) StoreSeasonSnippet(snippet Snippet) {
-	for i, snip := range s.QuerySnippets.ForSeason {
-		if snip.TitleSnippet == snippet.TitleSnippet && snip.FormatSnippet == snippet.FormatSnippet {
-			s.QuerySnippets.ForSeason[i] = snippet
-			return
-		}
-	}
+// The states an episode can be in, see State.
+const (
+	EpisodeDownloaded = "downloaded"
+	EpisodeWanted     = "wanted"
+	EpisodeUpcoming   = "upcoming"
+)
 
-	s.QuerySnippets.ForSeason = append(s.QuerySnippets.ForSeason, snippet)
+// State tells whether the episode is downloaded, wanted or still has to air.
+func (e *Episode) State(now time.Time) string {
+	switch {
+	case !e.Pending:
+		return EpisodeDownloaded
+	case e.AirDate.After(now):
+		return EpisodeUpcoming
+	default:
+		return EpisodeWanted
+	}
 }
 
-func (s *Show) BestEpisodeSnippet() Snippet {
-	var best Snippet
-	for _, snippet := range s.QuerySnippets.ForEpisode {
-		if snippet.Score >= best.Score {
-			best = snippet
-		}
+// Tried records a search for the episode. When the episode is still pending
+// the next search is backed off further.
+func (e *Episode) Tried(at time.Time) {
+	e.TriedAt = at
+	if e.Pending {
+		e.Backoff++
+	} else {
+		e.Backoff = 0
 	}
-	return best
 }
 
-func (s *Show) StoreEpisodeSnippet(snippet Snippet) {
-	for i, snip := range s.QuerySnippets.ForEpisode {
-		if snip.TitleSnippet == snippet.TitleSnippet && snip.FormatSnippet == snippet.FormatSnippet {
-			s.QuerySnippets.ForEpisode[i] = snippet
-			return
+// MonitoredSeasons returns the seasons whose episodes are wanted: every
+// season but the specials, unless the show is set to want those as well.
+func (s *Show) MonitoredSeasons() []*Season {
+	var seasons []*Season
+	for _, season := range s.Seasons {
+		if season.Season != 0 || s.Specials {
+			seasons = append(seasons, season)
 		}
 	}
-
-	s.QuerySnippets.ForEpisode = append(s.QuerySnippets.ForEpisode, snippet)
-}
-
-// Done flags an episode as 'downloaded' and thus done. This episode is
-// never looked up on a search engine agian.
-func (e *Episode) Done() {
-	e.Pending = false
+	return seasons
 }
 
 // PendingSeasons return a list which is to be downloaded.
@@ -149,7 +427,7 @@ func (e *Episode) Done() {
 // tend to bundle these).
 func (s *Show) PendingSeasons() []*Season {
 	var seasons []*Season
-	for _, season := range s.Seasons {
+	for _, season := range s.MonitoredSeasons() {
 		if s.isPending(season) {
 			seasons = append(seasons, season)
 		}
@@ -160,7 +438,7 @@ func (s *Show) PendingSeasons() []*Season {
 // PendingEpisodes return a list which is to be downloaded.
 func (s *Show) PendingEpisodes() []*Episode {
 	var episodes []*Episode
-	for _, season := range s.Seasons {
+	for _, season := range s.MonitoredSeasons() {
 		if !s.isPending(season) {
 			episodes = append(episodes, season.PendingEpisodes()...)
 		}
@@ -169,7 +447,8 @@ func (s *Show) PendingEpisodes() []*Episode {
 }
 
 func (s *Show) isPending(season *Season) bool {
-	if !season.allEpisodesPending() {
+	// Specials are searched for one by one, by title.
+	if !season.allEpisodesPending() || s.IsDaily() || season.Season == 0 {
 		return false
 	}
 
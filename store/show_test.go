@@ -7,29 +7,9 @@ import (
 
 	"github.com/haarts/getme/store"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestStoreEpisodeSnippet(t *testing.T) {
-	show := store.Show{}
-	snippet := store.Snippet{Score: 123, TitleSnippet: "abc", FormatSnippet: "sxs"}
-	show.StoreEpisodeSnippet(snippet)
-
-	assert.Equal(t, snippet, show.QuerySnippets.ForEpisode[0])
-}
-
-func TestOverWriteStoreEpisodeSnippet(t *testing.T) {
-	show := store.Show{}
-
-	snippet := store.Snippet{Score: 123, TitleSnippet: "abc", FormatSnippet: "sxs"}
-	show.StoreEpisodeSnippet(snippet)
-
-	snippet = store.Snippet{Score: 234, TitleSnippet: "abc", FormatSnippet: "sxs"}
-	show.StoreEpisodeSnippet(snippet)
-
-	assert.Len(t, show.QuerySnippets.ForEpisode, 1)
-	assert.Equal(t, snippet, show.QuerySnippets.ForEpisode[0])
-}
-
 func TestSortByAirDate(t *testing.T) {
 	episodes := []*store.Episode{
 		{AirDate: time.Now().Add(-5 * time.Hour), Title: "oldest"},
@@ -102,3 +82,160 @@ func TestEpisodes(t *testing.T) {
 		t.Error("Expected to have 4 episodes, got: ", len(s.Episodes()))
 	}
 }
+
+func TestIDFor(t *testing.T) {
+	show := store.Show{SourceName: "tvmaze", ID: 1}
+	show.LinkSource("trakt", 2)
+
+	ID, ok := show.IDFor("tvmaze")
+	assert.True(t, ok)
+	assert.Equal(t, 1, ID)
+
+	ID, ok = show.IDFor("trakt")
+	assert.True(t, ok)
+	assert.Equal(t, 2, ID)
+
+	_, ok = show.IDFor("tvrage")
+	assert.False(t, ok)
+}
+
+func TestSet(t *testing.T) {
+	show := store.Show{}
+
+	assert.NoError(t, show.Set("anime", "true"))
+	assert.True(t, show.Anime)
+	assert.Error(t, show.Set("anime", "sometimes"))
+	assert.True(t, show.Anime)
+	assert.Error(t, show.Set("colour", "blue"))
+
+	assert.False(t, show.IsDaily())
+	assert.NoError(t, show.Set("daily", "true"))
+	assert.True(t, show.IsDaily())
+
+	assert.NoError(t, show.Set("specials", "1"))
+	assert.True(t, show.Specials)
+
+	assert.NoError(t, show.Set("alternate_titles", "Agents of SHIELD; SHIELD ;"))
+	assert.Equal(t, []string{"Agents of SHIELD", "SHIELD"}, show.AlternateTitles)
+	assert.NoError(t, show.Set("alternate_titles", ""))
+	assert.Empty(t, show.AlternateTitles)
+}
+
+func TestTitles(t *testing.T) {
+	show := store.Show{
+		Title:           "Marvel's Agents of S.H.I.E.L.D.",
+		AlternateTitles: []string{"Agents of SHIELD"},
+		SourceTitles:    []string{"agents of shield", "Agents of S.H.I.E.L.D."},
+	}
+
+	assert.Equal(t, []string{"Marvel's Agents of S.H.I.E.L.D.", "Agents of SHIELD", "Agents of S.H.I.E.L.D."}, show.Titles())
+}
+
+func TestSpecialsAreOptIn(t *testing.T) {
+	show := store.Show{Seasons: []*store.Season{
+		{Season: 0, Episodes: []*store.Episode{{Pending: true}, {Pending: true}}},
+		{Season: 1, Episodes: []*store.Episode{{Pending: true}}},
+	}}
+
+	assert.Equal(t, 1, len(show.MonitoredSeasons()))
+	assert.Equal(t, 1, len(show.PendingEpisodes()))
+
+	show.Specials = true
+	assert.Equal(t, 2, len(show.MonitoredSeasons()))
+	assert.Equal(t, 3, len(show.PendingEpisodes()), "specials are searched for one by one")
+	for _, season := range show.PendingSeasons() {
+		assert.NotEqual(t, 0, season.Season)
+	}
+}
+
+func TestIsDaily(t *testing.T) {
+	airing := func(days ...int) *store.Show {
+		var episodes []*store.Episode
+		for i, day := range days {
+			episodes = append(episodes, &store.Episode{
+				Episode: i + 1,
+				AirDate: time.Date(2015, 9, 1, 23, 30, 0, 0, time.UTC).AddDate(0, 0, day),
+			})
+		}
+		return &store.Show{Seasons: []*store.Season{{Season: 1, Episodes: episodes}}}
+	}
+
+	assert.True(t, airing(0, 1, 2, 3, 6, 7, 8, 9, 10, 13, 14, 15, 16, 17, 20, 21, 22).IsDaily())
+	assert.False(t, airing(0, 7, 14, 21, 28).IsDaily(), "weekly")
+	assert.False(t, airing(0, 1, 2, 3, 4, 5).IsDaily(), "not airing long enough")
+	assert.False(t, airing(0, 0, 0, 0, 0, 0, 0, 0).IsDaily(), "released at once")
+	assert.False(t, airing(0, 0, 0, 0, 0, 0, 0, 0, 365, 365, 365, 365, 365).IsDaily(), "seasons released at once")
+
+	notDaily := false
+	set := airing(0, 1, 2, 3, 6, 7, 8, 9, 10, 13, 14, 15, 16, 17, 20, 21, 22)
+	set.Daily = &notDaily
+	assert.False(t, set.IsDaily(), "a setting isn't overruled")
+}
+
+func TestDailyShowsHaveNoPendingSeasons(t *testing.T) {
+	daily := true
+	show := store.Show{Daily: &daily, Seasons: []*store.Season{
+		{Season: 1, Episodes: []*store.Episode{{Pending: true}, {Pending: true}}},
+		{Season: 2, Episodes: []*store.Episode{{Pending: true}}},
+	}}
+
+	assert.Equal(t, 0, len(show.PendingSeasons()))
+	assert.Equal(t, 3, len(show.PendingEpisodes()))
+}
+
+func TestCopy(t *testing.T) {
+	ended := false
+	show := &store.Show{
+		Title:       "my show",
+		Ended:       &ended,
+		ExternalIDs: map[string]int{"tvmaze": 1},
+		Paused:      true,
+		Seasons: []*store.Season{
+			{Season: 1, Episodes: []*store.Episode{{Episode: 1, Pending: true}}},
+		},
+	}
+
+	c := show.Copy()
+	*c.Ended = true
+	c.ExternalIDs["tvdb"] = 2
+	c.Seasons[0].Episodes[0].Done()
+	c.Seasons = append(c.Seasons, &store.Season{Season: 2})
+	c.Paused = false
+
+	assert.False(t, *show.Ended)
+	assert.Equal(t, 1, len(show.ExternalIDs))
+	assert.True(t, show.Seasons[0].Episodes[0].Pending)
+	assert.Equal(t, 1, len(show.Seasons))
+
+	show.Merge(show.Copy(), c)
+	assert.True(t, *show.Ended)
+	assert.Equal(t, 2, len(show.Seasons))
+	assert.False(t, show.Seasons[0].Episodes[0].Pending)
+	assert.True(t, show.Paused, "settings are kept")
+}
+
+func TestMerge(t *testing.T) {
+	show := &store.Show{
+		Title: "my show",
+		Seasons: []*store.Season{
+			{Season: 1, Episodes: []*store.Episode{{Episode: 1, Pending: true}, {Episode: 2, Pending: true}}},
+		},
+	}
+	base := show.Copy()
+	changed := show.Copy()
+
+	// The job finds episode 1, tries episode 2 and learns of episode 3.
+	changed.Seasons[0].Episodes[0].Done()
+	changed.Seasons[0].Episodes[1].Tried(time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC))
+	changed.Seasons[0].Episodes = append(changed.Seasons[0].Episodes, &store.Episode{Episode: 3, Pending: true})
+	// Meanwhile episode 2 is marked as downloaded.
+	show.Seasons[0].Episodes[1].Done()
+
+	show.Merge(base, changed)
+	episodes := show.Seasons[0].Episodes
+	require.Equal(t, 3, len(episodes))
+	assert.False(t, episodes[0].Pending, "found by the job")
+	assert.False(t, episodes[1].Pending, "marked meanwhile")
+	assert.Equal(t, 1, episodes[1].Backoff, "tried by the job")
+	assert.True(t, episodes[2].Pending, "new")
+}
@@ -0,0 +1,19 @@
+package store
+
+// maxSnatches is how many snatches Snatches returns.
+const maxSnatches = 100
+
+// Snatches returns the most recent snatches from the history, the latest
+// first.
+func (s Store) Snatches() ([]HistoryEntry, error) {
+	snatched, err := s.History(HistoryFilter{Action: Snatched})
+	if err != nil {
+		return nil, err
+	}
+
+	snatches := []HistoryEntry{}
+	for i := len(snatched) - 1; i >= 0 && len(snatches) < maxSnatches; i-- {
+		snatches = append(snatches, snatched[i])
+	}
+	return snatches, nil
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+)
+
+// The media snippets are learned for.
+const (
+	EpisodeSnippets = "episode"
+	SeasonSnippets  = "season"
+	SeriesSnippets  = "series"
+)
+
+// SnippetStats is what is known about querying with a snippet: how often it
+// was queried with, how often a torrent was found and the average reward,
+// from 0 to 1, of those queries.
+type SnippetStats struct {
+	Attempts  int     `json:"attempts,omitempty"`
+	Successes int     `json:"successes,omitempty"`
+	Reward    float64 `json:"reward,omitempty"`
+}
+
+// Observe records a query with a reward from 0 to 1, 0 when nothing was
+// found.
+func (s *SnippetStats) Observe(reward float64) {
+	s.Attempts++
+	if reward > 0 {
+		s.Successes++
+	}
+	s.Reward += (reward - s.Reward) / float64(s.Attempts)
+}
+
+func (q *QuerySnippets) of(media string) *[]Snippet {
+	switch media {
+	case SeasonSnippets:
+		return &q.ForSeason
+	case SeriesSnippets:
+		return &q.ForSeries
+	}
+	return &q.ForEpisode
+}
+
+// Snippets returns the snippets the show was queried with for media.
+func (s *Show) Snippets(media string) []Snippet {
+	return *s.QuerySnippets.of(media)
+}
+
+// ObserveSnippet records the reward of querying media of the show with a
+// snippet, see SnippetStats.Observe. The score of a snippet which found a
+// torrent is the score of that torrent.
+func (s *Show) ObserveSnippet(media string, snippet Snippet, reward float64, score int) {
+	snippets := s.QuerySnippets.of(media)
+	observed := len(*snippets)
+	for i, snip := range *snippets {
+		if snip.TitleSnippet == snippet.TitleSnippet && snip.FormatSnippet == snippet.FormatSnippet {
+			observed = i
+			break
+		}
+	}
+	if observed == len(*snippets) {
+		*snippets = append(*snippets, Snippet{TitleSnippet: snippet.TitleSnippet, FormatSnippet: snippet.FormatSnippet})
+	}
+
+	(*snippets)[observed].Observe(reward)
+	if reward > 0 {
+		(*snippets)[observed].Score = score
+	}
+}
+
+// formats holds what is known about the format snippets across every show,
+// by media and format.
+type formats struct {
+	sync.RWMutex
+	stats map[string]map[string]SnippetStats
+}
+
+const snippetsFile = "snippets.json"
+
+// ObserveFormat records the reward of a query for media made with a format
+// snippet, for any show, and writes the formats to disk straight away.
+func (s Store) ObserveFormat(media, format string, reward float64) error {
+	if s.formats == nil {
+		return nil
+	}
+	s.formats.Lock()
+	defer s.formats.Unlock()
+
+	if s.formats.stats == nil {
+		s.formats.stats = map[string]map[string]SnippetStats{}
+	}
+	if s.formats.stats[media] == nil {
+		s.formats.stats[media] = map[string]SnippetStats{}
+	}
+	stats := s.formats.stats[media][format]
+	stats.Observe(reward)
+	s.formats.stats[media][format] = stats
+
+	b, err := json.MarshalIndent(s.formats.stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(s.stateDir, snippetsFile), b, 0644)
+}
+
+// Formats returns what is known about the format snippets for media across
+// every show, by format.
+func (s Store) Formats(media string) map[string]SnippetStats {
+	stats := map[string]SnippetStats{}
+	if s.formats == nil {
+		return stats
+	}
+	s.formats.RLock()
+	defer s.formats.RUnlock()
+
+	for format, stat := range s.formats.stats[media] {
+		stats[format] = stat
+	}
+	return stats
+}
+
+func (s *Store) deserializeFormats() error {
+	d, err := ioutil.ReadFile(path.Join(s.stateDir, snippetsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(d, &s.formats.stats)
+}
@@ -6,31 +6,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"regexp"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 // Store is the main access point for everything storage related.
+//
+// A store shared between goroutines, like the daemon and the API, must be
+// locked while reading or changing it and its shows.
 type Store struct {
-	shows    map[string]*Show
-	movies   map[string]*Movie
-	stateDir string
+	shows     map[string]*Show
+	movies    map[string]*Movie
+	stateDir  string
+	updateLog *UpdateLog
+	mu        *sync.Mutex
+	historyMu *sync.Mutex
+	blocklist *blocklist
+	formats   *formats
 }
 
 // Open gets the serialized data from disk and reconstitutes them.
 func Open(stateDir string) (*Store, error) {
 	store := &Store{
-		shows:    make(map[string]*Show),
-		stateDir: stateDir,
+		shows:     make(map[string]*Show),
+		stateDir:  stateDir,
+		mu:        &sync.Mutex{},
+		historyMu: &sync.Mutex{},
+		blocklist: &blocklist{},
+		formats:   &formats{},
 	}
 
 	store.deserializeShows()
+	err := store.deserializeUpdateLog()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Error reading the update log.")
+	}
+	err = store.deserializeBlocklist()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Error reading the blocklist.")
+	}
+	err = store.deserializeFormats()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Error reading the format snippets.")
+	}
 
 	return store, nil
 }
 
+// Lock locks the store for the calling goroutine.
+func (s Store) Lock() {
+	s.mu.Lock()
+}
+
+// Unlock releases the lock taken with Lock.
+func (s Store) Unlock() {
+	s.mu.Unlock()
+}
+
 // Backup copies the state file thus creating a backup. Useful when running
 // destructive operations on the state files.
 func (s Store) Backup() error {
@@ -48,7 +90,13 @@ func (s Store) Close() error {
 		}
 	}
 
-	return nil
+	return s.storeUpdateLog()
+}
+
+// Save writes a single show to disk. Use it to persist progress without
+// waiting for Close.
+func (s Store) Save(show *Show) error {
+	return s.store(show)
 }
 
 func (s Store) NewShow(sourceName string, ID int, URL, Title string) *Show {
@@ -76,6 +124,20 @@ func (s *Store) CreateShow(show *Show) error {
 	return nil
 }
 
+// RemoveShow removes a show from the store and from disk.
+func (s *Store) RemoveShow(title string) error {
+	if _, ok := s.shows[title]; !ok {
+		return fmt.Errorf("Show %s doesn't exist.", title)
+	}
+	delete(s.shows, title)
+
+	err := os.Remove(s.fileFor(title))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // Shows returns a list of shows.
 func (s *Store) Shows() map[string]*Show {
 	return s.shows
@@ -134,7 +196,7 @@ func (s Store) store(show *Show) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(path.Join(s.stateDir, "shows", titleAsFileName(show.Title)+".json"), b, 0644)
+	err = ioutil.WriteFile(s.fileFor(show.Title), b, 0644)
 	if err != nil {
 		return err
 	}
@@ -142,6 +204,10 @@ func (s Store) store(show *Show) error {
 	return nil
 }
 
+func (s Store) fileFor(title string) string {
+	return path.Join(s.stateDir, "shows", titleAsFileName(title)+".json")
+}
+
 func titleAsFileName(title string) string {
 	re := regexp.MustCompile("[^a-zA-Z0-9]")
 	return string(re.ReplaceAll([]byte(title), []byte("_")))
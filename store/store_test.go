@@ -4,8 +4,11 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/haarts/getme/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestClose(t *testing.T) {
@@ -25,6 +28,23 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestObserveFormat(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, _ := store.Open(testDir)
+	require.NoError(t, s.ObserveFormat(store.EpisodeSnippets, "%s S%02dE%02d", 0.5))
+	require.NoError(t, s.ObserveFormat(store.EpisodeSnippets, "%s S%02dE%02d", 0))
+
+	s, _ = store.Open(testDir)
+	stats := s.Formats(store.EpisodeSnippets)["%s S%02dE%02d"]
+	assert.Equal(t, store.SnippetStats{Attempts: 2, Successes: 1, Reward: 0.25}, stats)
+	assert.Empty(t, s.Formats(store.SeasonSnippets))
+}
+
 func TestCreateDuplicateShow(t *testing.T) {
 	testDir := "test_state_dir"
 	os.MkdirAll(path.Join(testDir, "shows"), 0755)
@@ -59,3 +79,166 @@ func TestReadShows(t *testing.T) {
 		t.Error("Expected to find 'my show'.")
 	}
 }
+
+func TestUpdateLogIsPersisted(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	lastRun := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	s, _ := store.Open(testDir)
+	assert.True(t, s.UpdateLog().LastRun.IsZero())
+	s.UpdateLog().LastRun = lastRun
+	s.UpdateLog().Stale = []string{"my show"}
+	require.NoError(t, s.Close())
+
+	s, _ = store.Open(testDir)
+	assert.True(t, lastRun.Equal(s.UpdateLog().LastRun))
+	assert.Equal(t, []string{"my show"}, s.UpdateLog().Stale)
+}
+
+func TestRemoveShow(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, _ := store.Open(testDir)
+	s.CreateShow(&store.Show{Title: "my show"})
+	require.NoError(t, s.Close())
+
+	require.NoError(t, s.RemoveShow("my show"))
+	assert.Equal(t, 0, len(s.Shows()))
+	_, err := os.Stat(path.Join(testDir, "shows", "my_show.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Error(t, s.RemoveShow("my show"))
+}
+
+func TestSnatches(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, _ := store.Open(testDir)
+	require.NoError(t, s.AppendHistory(store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 1}))
+	require.NoError(t, s.AppendHistory(store.HistoryEntry{Action: store.Selected, Show: "my show", Season: 1, Episode: 2}))
+	require.NoError(t, s.AppendHistory(store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 2}))
+
+	s, _ = store.Open(testDir)
+	snatches, err := s.Snatches()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(snatches))
+	assert.Equal(t, 2, snatches[0].Episode, "latest first")
+}
+
+func TestHistory(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	day := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, _ := store.Open(testDir)
+	require.NoError(t, s.AppendHistory(
+		store.HistoryEntry{At: day, Action: store.Rejected, Show: "my show", Engine: "kickass"},
+		store.HistoryEntry{At: day.AddDate(0, 0, 1), Action: store.Selected, Show: "my show", Engine: "torrentcd"},
+	))
+	s, _ = store.Open(testDir)
+	require.NoError(t, s.AppendHistory(
+		store.HistoryEntry{At: day.AddDate(0, 0, 2), Action: store.Snatched, Show: "other show", Engine: "kickass"},
+	))
+
+	entries, err := s.History(store.HistoryFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(entries))
+	assert.Equal(t, store.Rejected, entries[0].Action, "oldest first")
+
+	entries, _ = s.History(store.HistoryFilter{Show: "My Show"})
+	assert.Equal(t, 2, len(entries))
+
+	entries, _ = s.History(store.HistoryFilter{Engine: "kickass"})
+	assert.Equal(t, 2, len(entries))
+
+	entries, _ = s.History(store.HistoryFilter{Since: day.AddDate(0, 0, 1), Until: day.AddDate(0, 0, 2)})
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, store.Selected, entries[0].Action)
+}
+
+func TestMarkBad(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, _ := store.Open(testDir)
+	show := &store.Show{Title: "my show", Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+		{Episode: 1, Backoff: 2},
+		{Episode: 2},
+	}}}}
+	s.CreateShow(show)
+
+	_, err := s.MarkBad(show, 1, 1, "fake", time.Now())
+	assert.ErrorIs(t, err, store.ErrNotSnatched)
+
+	require.NoError(t, s.AppendHistory(
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 1, Torrent: "first", InfoHash: "abc"},
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 1, Torrent: "second"},
+	))
+
+	blocked, err := s.MarkBad(show, 1, 1, "fake", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "second", blocked.Torrent, "the last snatch is blocked")
+	assert.True(t, show.Seasons[0].Episodes[0].Pending)
+	assert.Equal(t, 0, show.Seasons[0].Episodes[0].Backoff)
+	assert.False(t, show.Seasons[0].Episodes[1].Pending)
+
+	s, _ = store.Open(testDir)
+	assert.True(t, s.IsBlocked("", "Second"))
+	assert.True(t, s.IsBlocked("def", "Second"), "blocked without info hash")
+	assert.False(t, s.IsBlocked("abc", "first"))
+
+	require.NoError(t, s.Block(store.BlockedTorrent{InfoHash: "fake", Title: "my show S01E02"}))
+	assert.True(t, s.IsBlocked("FAKE", "other"))
+	assert.True(t, s.IsBlocked("", "my show S01E02"))
+	assert.False(t, s.IsBlocked("real", "my show S01E02"), "the real release with the title of a fake")
+
+	entries, _ := s.History(store.HistoryFilter{})
+	assert.Equal(t, store.Blocked, entries[len(entries)-1].Action)
+}
+
+func TestMarkBadPack(t *testing.T) {
+	testDir := "test_state_dir"
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+	defer func() {
+		os.RemoveAll(testDir)
+	}()
+
+	s, _ := store.Open(testDir)
+	show := &store.Show{Title: "my show", Seasons: []*store.Season{
+		{Season: 1, Episodes: []*store.Episode{{Episode: 1}, {Episode: 2}, {Episode: 3}}},
+		{Season: 2, Episodes: []*store.Episode{{Episode: 1}}},
+	}}
+	s.CreateShow(show)
+	require.NoError(t, s.AppendHistory(
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 1, Torrent: "my show S01E01-E02", InfoHash: "abc"},
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 2, Torrent: "my show S01E01-E02", InfoHash: "abc"},
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 1, Episode: 3, Torrent: "my show S01E03", InfoHash: "def"},
+		store.HistoryEntry{Action: store.Snatched, Show: "my show", Season: 2, Torrent: "my show complete", InfoHash: "123"},
+	))
+
+	_, err := s.MarkBad(show, 1, 2, "fake", time.Now())
+	require.NoError(t, err)
+	assert.True(t, show.Seasons[0].Episodes[0].Pending, "the whole pack is wanted again")
+	assert.True(t, show.Seasons[0].Episodes[1].Pending)
+	assert.False(t, show.Seasons[0].Episodes[2].Pending)
+	assert.False(t, show.Seasons[1].Episodes[0].Pending)
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// UpdateLog records when the shows were refreshed from their sources. This
+// allows refreshing only the shows which changed since.
+type UpdateLog struct {
+	// LastRun is the start of the last update.
+	LastRun time.Time `json:"last_run"`
+	// LastFullRefresh is the start of the last update which refreshed every
+	// show regardless of whether it changed.
+	LastFullRefresh time.Time `json:"last_full_refresh"`
+	// Stale are the titles of the shows the last update should have
+	// refreshed but didn't, because refreshing them failed or the update
+	// was interrupted. The next update refreshes them whether they changed
+	// or not.
+	Stale []string `json:"stale,omitempty"`
+}
+
+const updateLogFile = "updates.json"
+
+// UpdateLog returns the update log. Changes to it are persisted by Close.
+func (s *Store) UpdateLog() *UpdateLog {
+	if s.updateLog == nil {
+		s.updateLog = &UpdateLog{}
+	}
+	return s.updateLog
+}
+
+func (s *Store) deserializeUpdateLog() error {
+	s.updateLog = &UpdateLog{}
+
+	d, err := ioutil.ReadFile(path.Join(s.stateDir, updateLogFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(d, s.updateLog)
+}
+
+func (s Store) storeUpdateLog() error {
+	if s.updateLog == nil {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.updateLog, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(s.stateDir, updateLogFile), b, 0644)
+}
@@ -0,0 +1,58 @@
+package torrents
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/haarts/getme/store"
+)
+
+// animeRelease is a release of a fansub group, like
+// '[Group] Title - 123 [1080p]' or the batch '[Group] Title - 01-12 [1080p]'.
+// The episodes are numbered absolutely.
+type animeRelease struct {
+	group    string
+	title    string
+	from, to int
+}
+
+var animeReleasePattern = regexp.MustCompile(`^\s*(?:\[([^\]]+)\]\s*)?(.+?)\s+-\s+(\d{1,4})(?:\s*[-~]\s*(\d{1,4}))?(?:v\d+)?(?:[\s\[(.]|$)`)
+
+// parseAnimeRelease parses the title of a fansub release.
+func parseAnimeRelease(title string) (animeRelease, bool) {
+	matches := animeReleasePattern.FindStringSubmatch(title)
+	if matches == nil {
+		return animeRelease{}, false
+	}
+
+	release := animeRelease{group: matches[1], title: matches[2]}
+	release.from, _ = strconv.Atoi(matches[3])
+	release.to = release.from
+	if matches[4] != "" {
+		release.to, _ = strconv.Atoi(matches[4])
+	}
+	if release.from == 0 || release.to < release.from {
+		return animeRelease{}, false
+	}
+	return release, true
+}
+
+// animeRelease parses a title as a fansub release, for jobs of anime only.
+func (job queryJob) animeRelease(title string) (animeRelease, bool) {
+	if !job.anime {
+		return animeRelease{}, false
+	}
+	return parseAnimeRelease(title)
+}
+
+// byAbsolute returns the episodes whose absolute number is from from up to
+// and including to.
+func byAbsolute(episodes []*store.Episode, from, to int) []*store.Episode {
+	var found []*store.Episode
+	for _, e := range episodes {
+		if e.Absolute != 0 && e.Absolute >= from && e.Absolute <= to {
+			found = append(found, e)
+		}
+	}
+	return found
+}
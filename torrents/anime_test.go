@@ -0,0 +1,46 @@
+package torrents
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haarts/getme/store"
+)
+
+func TestParseAnimeRelease(t *testing.T) {
+	for title, expected := range map[string]animeRelease{
+		"[SubsPlease] One Piece - 1071 (1080p) [ABCD1234].mkv": {"SubsPlease", "One Piece", 1071, 1071},
+		"[Erai-raws] Title - 05v2 [720p]":                      {"Erai-raws", "Title", 5, 5},
+		"[Group] Title - 01-12 [1080p] [Batch]":                {"Group", "Title", 1, 12},
+		"[Group] Title - 13 ~ 24 [BD]":                         {"Group", "Title", 13, 24},
+		"Title - 07 [480p]":                                    {"", "Title", 7, 7},
+	} {
+		release, ok := parseAnimeRelease(title)
+		assert.True(t, ok, title)
+		assert.Equal(t, expected, release, title)
+	}
+
+	for _, title := range []string{"Title S01E01 720p", "[Group] Title 720p", "Title - 12-03"} {
+		_, ok := parseAnimeRelease(title)
+		assert.False(t, ok, title)
+	}
+}
+
+func TestFansubGroups(t *testing.T) {
+	p := Preferences{ReleaseGroups: []string{"KILLERS"}, FansubGroups: []string{"SubsPlease"}}
+	release := Torrent{Title: "[SubsPlease] Title - 05 (1080p)"}
+
+	assert.Equal(t, "SubsPlease", releaseGroup(release.Title))
+	assert.Equal(t, 1.0, releaseGroupFactor(queryJob{anime: true}, release, p))
+	assert.Equal(t, 0.0, releaseGroupFactor(queryJob{}, release, p))
+}
+
+func TestEpisodeQuery(t *testing.T) {
+	episode := &store.Episode{Episode: 5, Absolute: 17}
+	absolute := store.Snippet{TitleSnippet: "Title", FormatSnippet: "%s %02d"}
+
+	assert.Equal(t, "Title 17", episodeQuery(&store.Show{Anime: true}, absolute, episode))
+	assert.Equal(t, "Title S00E05", episodeQuery(&store.Show{}, absolute, episode), "not anime")
+	assert.Equal(t, "Title S00E05", episodeQuery(&store.Show{Anime: true}, absolute, &store.Episode{Episode: 5}), "no absolute number")
+}
@@ -0,0 +1,167 @@
+package torrents
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/store"
+)
+
+// priorWeight caps the number of queries the stats of a format across every
+// show count for in the estimate of a snippet of one show. The queries made
+// with the snippet itself soon outweigh them.
+const priorWeight = 3
+
+// random breaks ties between snippets which are equally promising. Tests
+// seed it.
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randomIntn(n int) int {
+	random.Lock()
+	defer random.Unlock()
+	return random.Intn(n)
+}
+
+// arm is a snippet the bandit can query with.
+type arm struct {
+	snippet store.Snippet
+	// queries counts the queries made with the snippet, and those of the
+	// format across shows counting towards it.
+	queries float64
+	mean    float64
+}
+
+// selectSnippet picks the snippet to query media of a show with among every
+// title snippet in every format, treating them as the arms of a multi-armed
+// bandit (UCB1): the snippet with the highest average reward plus a bonus
+// for being queried with little wins. Snippets never queried with are tried
+// first, unless what is known about their format across every show tells how
+// well they'll do. It returns whether the snippet is explored, which is when
+// it isn't the best one so far.
+func selectSnippet(show *store.Show, media string, formats []string) (store.Snippet, bool) {
+	sort.Strings(formats)
+	known := map[store.Snippet]store.SnippetStats{}
+	for _, snippet := range show.Snippets(media) {
+		known[store.Snippet{TitleSnippet: snippet.TitleSnippet, FormatSnippet: snippet.FormatSnippet}] = statsOf(snippet)
+	}
+	priors := map[string]store.SnippetStats{}
+	if s := usedStore(); s != nil {
+		priors = s.Formats(media)
+	}
+
+	var arms []arm
+	var total float64
+	for _, format := range formats {
+		prior := priors[format]
+		weight := math.Min(float64(prior.Attempts), priorWeight)
+		for _, title := range titleSnippets(show) {
+			snippet := store.Snippet{TitleSnippet: title, FormatSnippet: format}
+			stats := known[snippet]
+			a := arm{snippet: snippet, queries: float64(stats.Attempts) + weight}
+			if a.queries > 0 {
+				a.mean = (stats.Reward*float64(stats.Attempts) + prior.Reward*weight) / a.queries
+			}
+			arms = append(arms, a)
+			total += a.queries
+		}
+	}
+
+	var best, chosen []arm
+	bestMean, chosenBound := -1.0, -1.0
+	for _, a := range arms {
+		bound := math.Inf(1)
+		if a.queries > 0 {
+			bound = a.mean + math.Sqrt(2*math.Log(total)/a.queries)
+			best, bestMean = keepMax(best, bestMean, a, a.mean)
+		}
+		chosen, chosenBound = keepMax(chosen, chosenBound, a, bound)
+	}
+
+	pick := chosen[randomIntn(len(chosen))]
+	explored := true
+	for _, a := range best {
+		if a.snippet == pick.snippet {
+			explored = false
+		}
+	}
+	log.WithFields(log.Fields{
+		"title_snippet":  pick.snippet.TitleSnippet,
+		"format_snippet": pick.snippet.FormatSnippet,
+		"queries":        pick.queries,
+		"mean":           pick.mean,
+		"explored":       explored,
+	}).Debug("Selected snippet")
+	return pick.snippet, explored
+}
+
+// statsOf returns the stats of a snippet. Snippets learned before they were
+// counted only have a score, which used to be the number of seeds. They count
+// as one query with a reward of at most 1.
+func statsOf(snippet store.Snippet) store.SnippetStats {
+	if snippet.Attempts == 0 && snippet.Score > 0 {
+		return store.SnippetStats{Attempts: 1, Successes: 1, Reward: math.Min(1, float64(snippet.Score)/100)}
+	}
+	return snippet.SnippetStats
+}
+
+// keepMax keeps the arms with the highest value.
+func keepMax(arms []arm, max float64, a arm, value float64) ([]arm, float64) {
+	switch {
+	case value > max:
+		return []arm{a}, value
+	case value == max:
+		return append(arms, a), max
+	}
+	return arms, max
+}
+
+// snippetMedia returns what a job's snippet is learned for, the empty
+// string for specials, which aren't queried with snippets.
+func snippetMedia(job queryJob) string {
+	switch job.media.(type) {
+	case *store.Season:
+		return store.SeasonSnippets
+	case Series:
+		return store.SeriesSnippets
+	}
+	if isSpecial(job.media) {
+		return ""
+	}
+	return store.EpisodeSnippets
+}
+
+// learnSnippet rewards the snippet of a job with the score of the torrent
+// found with it, from 0 to 1, or with 0 when nothing was found. The format
+// of the snippet is rewarded across shows as well.
+func learnSnippet(show *store.Show, job queryJob, torrent *Torrent) {
+	media := snippetMedia(job)
+	if media == "" {
+		return
+	}
+
+	var reward float64
+	var score int
+	if torrent != nil {
+		reward = torrent.Score.Total / 100
+		score = int(math.Round(torrent.Score.Total))
+	}
+	show.ObserveSnippet(media, job.snippet, reward, score)
+
+	s := usedStore()
+	if s == nil || job.dryRun {
+		return
+	}
+	if err := s.ObserveFormat(media, job.snippet.FormatSnippet, reward); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to record the format snippets.")
+	}
+}
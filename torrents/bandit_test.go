@@ -0,0 +1,101 @@
+package torrents
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+)
+
+func seed(s int64) func() {
+	original := random.Rand
+	random.Rand = rand.New(rand.NewSource(s))
+	return func() { random.Rand = original }
+}
+
+var formats = []string{"%s S%02dE%02d", "%s %dx%d"}
+
+func TestSelectSnippetTriesEverySnippet(t *testing.T) {
+	defer seed(1)()
+	show := &store.Show{Title: "Title"}
+
+	first, explored := selectSnippet(show, store.EpisodeSnippets, formats)
+	assert.True(t, explored, "nothing is known yet")
+	show.ObserveSnippet(store.EpisodeSnippets, first, 0.9, 90)
+
+	second, _ := selectSnippet(show, store.EpisodeSnippets, formats)
+	assert.NotEqual(t, first.FormatSnippet, second.FormatSnippet)
+}
+
+func TestSelectSnippetKeepsHistory(t *testing.T) {
+	defer seed(1)()
+	good := store.Snippet{TitleSnippet: "Title", FormatSnippet: "%s S%02dE%02d"}
+	bad := store.Snippet{TitleSnippet: "Title", FormatSnippet: "%s %dx%d"}
+	show := &store.Show{Title: "Title"}
+	for i := 0; i < 10; i++ {
+		show.ObserveSnippet(store.EpisodeSnippets, good, 0.8, 80)
+		show.ObserveSnippet(store.EpisodeSnippets, bad, 0, 0)
+	}
+
+	selected, explored := selectSnippet(show, store.EpisodeSnippets, formats)
+	assert.Equal(t, good, selected)
+	assert.False(t, explored)
+
+	show.ObserveSnippet(store.EpisodeSnippets, good, 0, 0)
+	selected, _ = selectSnippet(show, store.EpisodeSnippets, formats)
+	assert.Equal(t, good, selected, "one miss doesn't erase history")
+
+	stats := show.Snippets(store.EpisodeSnippets)[0]
+	assert.Equal(t, 11, stats.Attempts)
+	assert.Equal(t, 10, stats.Successes)
+	assert.InDelta(t, 0.727, stats.Reward, 0.001)
+	assert.Equal(t, 80, stats.Score)
+}
+
+func TestStatsOfLegacySnippets(t *testing.T) {
+	assert.Equal(t, 1.0, statsOf(store.Snippet{Score: 2500}).Reward, "seeds")
+	assert.Equal(t, 0.4, statsOf(store.Snippet{Score: 40}).Reward)
+	assert.Equal(t, 3, statsOf(store.Snippet{Score: 40, SnippetStats: store.SnippetStats{Attempts: 3}}).Attempts)
+}
+
+func TestSelectSnippetLearnsAcrossShows(t *testing.T) {
+	defer seed(1)()
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	UseStore(s)
+	defer UseStore(nil)
+
+	season := store.Season{Season: 1, Episodes: []*store.Episode{{Pending: true, Episode: 1}}}
+	episode := season.PendingEpisodes()[0]
+	other := &store.Show{Title: "Other"}
+	for i := 0; i < 20; i++ {
+		learnSnippet(other, queryJob{media: episode, snippet: store.Snippet{TitleSnippet: "Other", FormatSnippet: "%s %dx%d"}}, &Torrent{Score: Score{Total: 70}})
+		learnSnippet(other, queryJob{media: episode, snippet: store.Snippet{TitleSnippet: "Other", FormatSnippet: "%s S%02dE%02d"}}, nil)
+	}
+
+	selected, explored := selectSnippet(&store.Show{Title: "Title"}, store.EpisodeSnippets, formats)
+	assert.Equal(t, "%s %dx%d", selected.FormatSnippet)
+	assert.False(t, explored)
+}
+
+func TestSelectSnippetIsDeterministic(t *testing.T) {
+	show := &store.Show{Title: "A long title with many words"}
+	pick := func() []store.Snippet {
+		defer seed(42)()
+		var picked []store.Snippet
+		for i := 0; i < 5; i++ {
+			snippet, _ := selectSnippet(show, store.EpisodeSnippets, formats)
+			picked = append(picked, snippet)
+		}
+		return picked
+	}
+
+	assert.Equal(t, pick(), pick())
+}
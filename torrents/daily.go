@@ -0,0 +1,41 @@
+package torrents
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// datePattern matches air dates like 2015.09.01, 2015-09-01 and 2015 09 01.
+var datePattern = regexp.MustCompile(`\b((?:19|20)\d{2})[ ._-](\d{2})[ ._-](\d{2})\b`)
+
+// datesIn returns the air dates a title or a file name says it contains.
+func datesIn(name string) []time.Time {
+	var dates []time.Time
+	for _, match := range datePattern.FindAllStringSubmatch(name, -1) {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		// Reject dates like 2015.13.01, which time.Date normalizes.
+		if date.Month() != time.Month(month) || date.Day() != day {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// containsDate tells whether the day of airDate is one of dates.
+func containsDate(dates []time.Time, airDate time.Time) bool {
+	if airDate.IsZero() {
+		return false
+	}
+	y, m, d := airDate.Date()
+	for _, date := range dates {
+		if date.Year() == y && date.Month() == m && date.Day() == d {
+			return true
+		}
+	}
+	return false
+}
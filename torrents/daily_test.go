@@ -0,0 +1,43 @@
+package torrents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haarts/getme/store"
+)
+
+func TestDatesIn(t *testing.T) {
+	day := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	for _, title := range []string{
+		"Show 2015.09.01 Guest 720p HDTV x264-SORNY",
+		"Show.2015-09-01.720p",
+		"Show 2015 09 01 HDTV",
+	} {
+		assert.Equal(t, []time.Time{day}, datesIn(title), title)
+	}
+	assert.Empty(t, datesIn("Show 2015.13.01"))
+	assert.Empty(t, datesIn("Show S01E02 1080p"))
+}
+
+func TestDailyEpisodes(t *testing.T) {
+	daily := true
+	show := &store.Show{Daily: &daily}
+	season := store.Season{Season: 1, Episodes: []*store.Episode{
+		{Pending: true, Episode: 3, AirDate: time.Date(2015, 9, 1, 23, 35, 0, 0, time.UTC)},
+	}}
+	episode := season.PendingEpisodes()[0]
+
+	assert.Equal(t, "Show 2015.09.01", episodeQuery(show, store.Snippet{TitleSnippet: "Show", FormatSnippet: "%s %d.%02d.%02d"}, episode))
+	assert.Equal(t, "Show 2015.09.01", episodeQuery(show, store.Snippet{TitleSnippet: "Show", FormatSnippet: "%s S%02dE%02d"}, episode), "only dates")
+	for format := range episodeAlternatives(show) {
+		assert.Contains(t, dailyQueryAlternatives, format)
+	}
+
+	job := queryJob{media: episode, daily: true}
+	assert.True(t, isEpisode(job, "Show 2015.09.01 720p HDTV"))
+	assert.False(t, isEpisode(job, "Show 2015.09.02 720p HDTV"))
+	assert.True(t, isEpisode(job, "Show 720p HDTV"))
+}
@@ -2,51 +2,65 @@ package torrents
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
 	"net/http"
 	"os"
 	"path"
-	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/jackpal/bencode-go"
+
+	"github.com/haarts/getme/hooks"
+	"github.com/haarts/getme/metrics"
+	"github.com/haarts/getme/notify"
+	"github.com/haarts/getme/request"
+	"github.com/haarts/getme/store"
 )
 
-var timeout = 2 * time.Second
-var requestDelay = 5 * time.Second
+var downloads = metrics.NewCounter(
+	"getme_downloads_total",
+	"Torrent files downloaded, by whether the download succeeded.",
+	"result")
 
 // Download takes a slice of torrents and downloads them to destination.
-// It rate limits the requests per host. And times requests out after a
-// while.
-func Download(foundTorrents []Torrent, destination string) error {
-	tickers := map[string]<-chan time.Time{}
-	var mu sync.Mutex
-	relevantTicker := func(host string) <-chan time.Time {
-		if ticker, ok := tickers[host]; ok {
-			return ticker
-		}
-		mu.Lock()
-		tickers[host] = time.Tick(requestDelay)
-		mu.Unlock()
-		return tickers[host]
-	}
-
-	errors := make(chan error)
-	for _, foundTorrent := range foundTorrents {
-		go func(t Torrent) {
-			<-relevantTicker(t.URL.Host) // rate limit ourselves
-			err := downloadWithTimeout(t, destination)
-			if err == nil {
+// The requests are rate limited per host and timed out by the request
+// package. The post download hook runs for every torrent written. The info
+// hashes of the torrents are filled in from their metainfo when unknown.
+func Download(ctx context.Context, foundTorrents []Torrent, destination string) error {
+	errors := make(chan error, len(foundTorrents))
+	for i := range foundTorrents {
+		go func(t *Torrent) {
+			meta, err := download(ctx, *t, destination)
+			if t.InfoHash == "" {
+				t.InfoHash = meta.InfoHash
+			}
+			if err != nil {
+				downloads.Inc("failure")
+				record(torrentEntry(store.Failed, *t, err.Error()))
+				blockBadPayload(*t, err)
+			} else {
+				downloads.Inc("success")
 				log.WithFields(log.Fields{
 					"torrent": t.URL,
 				}).Debug("Download successful")
 
 				t.AssociatedMedia.Done()
+
+				season, episode := seasonAndEpisode(t.AssociatedMedia)
+				hooks.Run(ctx, hooks.Details{
+					Hook:    hooks.PostDownload,
+					Show:    t.Show,
+					Season:  season,
+					Episode: episode,
+					Torrent: t.Title,
+					URL:     t.URL.String(),
+					Seeds:   t.seeds,
+					File:    path.Join(destination, t.Filename),
+				})
 			}
 			errors <- err
-		}(foundTorrent)
+		}(&foundTorrents[i])
 	}
 
 	var err error
@@ -56,41 +70,96 @@ func Download(foundTorrents []Torrent, destination string) error {
 	return err
 }
 
-func downloadWithTimeout(torrent Torrent, destination string) error {
-	result := make(chan error)
-	go func() {
-		result <- download(torrent, destination)
-	}()
-
-	select {
-	case <-time.After(timeout):
-		return fmt.Errorf("download timed out on '%s'", torrent.URL)
-	case err := <-result:
-		return err
+// snatchesOf returns the snatched history entry of the season or the episode
+// a torrent completed, or one for every episode of a pack it completed.
+func snatchesOf(show *store.Show, t Torrent, at time.Time) []store.HistoryEntry {
+	snatch := torrentEntry(store.Snatched, t, "")
+	snatch.Show = show.Title
+	snatch.At = at
+
+	var episodes Pack
+	switch media := t.AssociatedMedia.(type) {
+	case *store.Season:
+		if len(media.PendingEpisodes()) != 0 {
+			return nil
+		}
+		snatch.Season = media.Season
+		snatch.Episode = 0
+		return []store.HistoryEntry{snatch}
+	case Series:
+		var snatches []store.HistoryEntry
+		for _, season := range media {
+			seasonTorrent := t
+			seasonTorrent.AssociatedMedia = season
+			snatches = append(snatches, snatchesOf(show, seasonTorrent, at)...)
+		}
+		return snatches
+	case *store.Episode:
+		episodes = Pack{media}
+	case Pack:
+		episodes = media
+	}
+
+	var snatches []store.HistoryEntry
+	for _, episode := range episodes {
+		if episode.Pending {
+			continue
+		}
+		snatch.Season = episode.Season()
+		snatch.Episode = episode.Episode
+		snatches = append(snatches, snatch)
+	}
+	return snatches
+}
+
+// RecordSnatches adds the seasons and episodes the downloaded torrents
+// completed to the history and notifies about them.
+func RecordSnatches(ctx context.Context, s *store.Store, show *store.Show, downloaded []Torrent, at time.Time) {
+	for _, t := range downloaded {
+		for _, snatch := range snatchesOf(show, t, at) {
+			if err := s.AppendHistory(snatch); err != nil {
+				log.WithFields(log.Fields{
+					"err":     err,
+					"show":    show.Title,
+					"torrent": snatch.Torrent,
+				}).Error("Failed to record history.")
+			}
+
+			notify.Send(ctx, notify.Event{
+				Type:    notify.Snatched,
+				Show:    snatch.Show,
+				Season:  snatch.Season,
+				Episode: snatch.Episode,
+				Torrent: snatch.Torrent,
+				Message: "Downloaded " + snatch.Torrent,
+				At:      snatch.At,
+			})
+		}
 	}
 }
 
-func download(torrent Torrent, directory string) error {
+func download(ctx context.Context, torrent Torrent, directory string) (Metainfo, error) {
 	logEntry := log.WithFields(log.Fields{
 		"torrent": torrent.Filename,
 	})
 
-	req, err := http.NewRequest("GET", torrent.URL.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", torrent.URL.String(), nil)
 	if err != nil {
 		logEntry.WithFields(log.Fields{
 			"err": err,
 		}).Warn("Request construction failed")
+		return Metainfo{}, err
 	}
 
 	// Be nice and tell them who we are.
 	req.Header.Set("User-Agent", "github.com/haarts/getme")
 
-	response, err := http.DefaultClient.Do(req)
+	response, err := request.Do(req)
 	if err != nil {
 		logEntry.WithFields(log.Fields{
 			"err": err,
 		}).Warn("Download failed")
-		return err
+		return Metainfo{}, err
 	}
 	defer response.Body.Close()
 
@@ -100,17 +169,24 @@ func download(torrent Torrent, directory string) error {
 		logEntry.WithFields(log.Fields{
 			"err": err,
 		}).Warn("Reading response body failed")
-		return err
+		return Metainfo{}, err
 	}
 
-	copy := &bytes.Buffer{}
-	*copy = *buf
-	_, err = bencode.Decode(copy)
+	meta, err := ParseMetainfo(buf.Bytes())
 	if err != nil {
 		logEntry.WithFields(log.Fields{
 			"err": err,
 		}).Warn("Torrent could not be decoded")
-		return err
+		return Metainfo{}, err
+	}
+
+	err = verifyPayload(meta, torrent.AssociatedMedia)
+	if err != nil {
+		logEntry.WithFields(log.Fields{
+			"err":   err,
+			"files": len(meta.Files),
+		}).Warn("Torrent payload rejected")
+		return meta, err
 	}
 
 	file, err := os.Create(path.Join(directory, torrent.Filename))
@@ -118,7 +194,7 @@ func download(torrent Torrent, directory string) error {
 		logEntry.WithFields(log.Fields{
 			"err": err,
 		}).Warn("File creation failed")
-		return err
+		return meta, err
 	}
 	defer file.Close()
 
@@ -136,8 +212,8 @@ func download(torrent Torrent, directory string) error {
 			"err": err,
 		}).Warn("Copy to file failed")
 		_ = cleanup()
-		return err
+		return meta, err
 	}
 
-	return nil
+	return meta, nil
 }
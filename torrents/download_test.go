@@ -1,6 +1,7 @@
 package torrents_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -38,7 +39,7 @@ func TestDownloadNonTorrent(t *testing.T) {
 		AssociatedMedia: mockDoner{},
 	}
 
-	torrents.Download([]torrents.Torrent{torrent}, "/tmp")
+	torrents.Download(context.Background(), []torrents.Torrent{torrent}, "/tmp")
 
 	// The torrent should NOT be stored
 	_, err := os.Stat("/tmp/baz")
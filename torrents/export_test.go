@@ -1,5 +1,7 @@
 package torrents
 
+import "github.com/haarts/getme/store"
+
 var IsEnglish = isEnglish
 var IsSeason = isSeason
 
@@ -10,3 +12,23 @@ func NewQueryJob(season int) queryJob {
 		season: season,
 	}
 }
+
+var InfoHashFromURL = infoHashFromURL
+
+var VerifyPayload = verifyPayload
+
+func EpisodesIn(name string) [][2]int {
+	var episodes [][2]int
+	for _, n := range episodesIn(name) {
+		episodes = append(episodes, [2]int{n.season, n.episode})
+	}
+	return episodes
+}
+
+func IsCompleteSeries(seasons []int, title string) bool {
+	var series Series
+	for _, season := range seasons {
+		series = append(series, &store.Season{Season: season})
+	}
+	return isCompleteSeries(queryJob{media: series}, title)
+}
@@ -1,6 +1,7 @@
 package torrents
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/sources"
 )
 
@@ -26,6 +28,9 @@ type extraTorrentItem struct {
 	Title     string `xml:"title"`
 	InfoHash  string `xml:"info_hash"`
 	Seeders   string `xml:"seeders"`
+	Leechers  string `xml:"leechers"`
+	Size      int64  `xml:"size"`
+	PubDate   string `xml:"pubDate"`
 	Enclosure struct {
 		URL string `xml:"url,attr"`
 	} `xml:"enclosure"`
@@ -41,8 +46,15 @@ func (e ExtraTorrent) Name() string {
 	return "extratorrent"
 }
 
-func (e ExtraTorrent) Search(query string) ([]Torrent, error) {
-	req, err := http.NewRequest(
+// Hosts returns the host of the search engine, torrents are downloaded from
+// the same host.
+func (e ExtraTorrent) Hosts() []string {
+	return []string{request.HostOf(e.URL)}
+}
+
+func (e ExtraTorrent) Search(ctx context.Context, query string) ([]Torrent, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		e.URL+fmt.Sprintf("/search/?search=%s&new=1&x=0&y=0", query),
 		nil,
@@ -82,15 +94,20 @@ func (e ExtraTorrent) Search(query string) ([]Torrent, error) {
 		if err != nil {
 			continue
 		}
+		leechers, _ := strconv.Atoi(item.Leechers)
 
 		parts := strings.Split(url.Path, "/")
 		filename := parts[len(parts)-1]
 
 		torrent := Torrent{
-			URL:      url,
-			Filename: filename,
-			Title:    item.Title,
-			seeds:    seeds,
+			URL:       url,
+			Filename:  filename,
+			Title:     item.Title,
+			seeds:     seeds,
+			leechers:  leechers,
+			Size:      item.Size,
+			Published: parsePubDate(item.PubDate),
+			InfoHash:  strings.ToLower(item.InfoHash),
 		}
 		torrents = append(torrents, torrent)
 	}
@@ -1,6 +1,7 @@
 package torrents_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -27,8 +28,10 @@ func TestExtraTorrentSearch(t *testing.T) {
 		URL: ts.URL,
 	}
 
-	results, err := engine.Search("foo")
+	results, err := engine.Search(context.Background(), "foo")
 	require.NoError(t, err)
 	require.Len(t, results, 3)
 	assert.Equal(t, "One Flew Over The Cuckoos Nest (1975) 720p MKV x264 AC3 BRrip [Pioneer]", results[0].Title)
+	assert.Equal(t, int64(1236123164), results[0].Size)
+	assert.Equal(t, 2013, results[0].Published.Year())
 }
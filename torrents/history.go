@@ -0,0 +1,141 @@
+package torrents
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/store"
+)
+
+// used is the store the decisions about torrents are recorded in and
+// whose blocklist is respected, see UseStore.
+var used = struct {
+	sync.RWMutex
+	store *store.Store
+}{}
+
+// UseStore has every decision about a torrent, from rejecting it to
+// downloading it, recorded in the history of s and the torrents on the
+// blocklist of s skipped. Nil stops both.
+func UseStore(s *store.Store) {
+	used.Lock()
+	defer used.Unlock()
+	used.store = s
+}
+
+func usedStore() *store.Store {
+	used.RLock()
+	defer used.RUnlock()
+	return used.store
+}
+
+// record appends entries to the history, if a store is used.
+func record(entries ...store.HistoryEntry) {
+	s := usedStore()
+	if s == nil || len(entries) == 0 {
+		return
+	}
+	if err := s.AppendHistory(entries...); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to record history.")
+	}
+}
+
+// record appends entries to the history unless the job is a dry run.
+func (job queryJob) record(entries ...store.HistoryEntry) {
+	if !job.dryRun {
+		record(entries...)
+	}
+}
+
+// historyEntry describes what was decided about a torrent found for a job.
+func (job queryJob) historyEntry(action string, torrent Torrent, reason string) store.HistoryEntry {
+	season, episode := seasonAndEpisode(job.media)
+	entry := torrentEntry(action, torrent, reason)
+	entry.Show = job.show
+	entry.Season = season
+	entry.Episode = episode
+	entry.Query = job.query
+	return entry
+}
+
+// torrentEntry describes what was decided about a torrent.
+func torrentEntry(action string, torrent Torrent, reason string) store.HistoryEntry {
+	season, episode := seasonAndEpisode(torrent.AssociatedMedia)
+	entry := store.HistoryEntry{
+		At:       time.Now(),
+		Action:   action,
+		Show:     torrent.Show,
+		Season:   season,
+		Episode:  episode,
+		Engine:   torrent.Engine,
+		Torrent:  torrent.Title,
+		Seeds:    torrent.seeds,
+		InfoHash: torrent.InfoHash,
+		Reason:   reason,
+	}
+	if torrent.URL != nil {
+		entry.URL = torrent.URL.String()
+	}
+	return entry
+}
+
+// blockBadPayload puts a torrent whose payload isn't what it claims to be on
+// the blocklist, if a store is used, so it isn't downloaded again.
+func blockBadPayload(torrent Torrent, err error) {
+	s := usedStore()
+	if s == nil || !errors.Is(err, errBadPayload) {
+		return
+	}
+	err = s.Block(store.BlockedTorrent{
+		InfoHash: torrent.InfoHash,
+		Title:    torrent.Title,
+		Reason:   err.Error(),
+		At:       time.Now(),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":     err,
+			"torrent": torrent.Title,
+		}).Error("Failed to block torrent.")
+	}
+}
+
+var hexInfoHash = regexp.MustCompile(`(?i)\b[0-9a-f]{40}\b`)
+
+// infoHashFromURL finds the info hash in magnet links and in the URLs of
+// torrent caches, which name the torrent after its info hash.
+func infoHashFromURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	if u.Scheme == "magnet" {
+		for _, xt := range u.Query()["xt"] {
+			if !strings.HasPrefix(xt, "urn:btih:") {
+				continue
+			}
+			hash := strings.TrimPrefix(xt, "urn:btih:")
+			if len(hash) == 32 {
+				decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+				if err != nil {
+					return ""
+				}
+				return hex.EncodeToString(decoded)
+			}
+			return strings.ToLower(hash)
+		}
+		return ""
+	}
+
+	return strings.ToLower(hexInfoHash.FindString(u.Path))
+}
@@ -0,0 +1,78 @@
+package torrents_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+func TestSearchRecordsHistory(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01 FRENCH", "Title S01E01"}})()
+
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	torrents.UseStore(s)
+	defer torrents.UseStore(nil)
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+	_, err = torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	entries, err := s.History(store.HistoryFilter{Engine: "fake"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, store.Rejected, entries[0].Action)
+	assert.Equal(t, "Title S01E01 FRENCH", entries[0].Torrent)
+	assert.Equal(t, "not in English", entries[0].Reason)
+	assert.Equal(t, store.Selected, entries[1].Action)
+	assert.Equal(t, "Title", entries[1].Show)
+	assert.Equal(t, 1, entries[1].Season)
+	assert.Equal(t, 1, entries[1].Episode)
+}
+
+func TestInfoHashFromURL(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=x":            "c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+		"magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK":                         "c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+		"http://torcache.net/torrent/C12FE1C06BBA254A9DC9F519B335AA7C1367A88A.torrent": "c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+		"http://example.com/Title":                                                     "",
+	} {
+		u, _ := url.Parse(raw)
+		assert.Equal(t, expected, torrents.InfoHashFromURL(u), raw)
+	}
+}
+
+func TestBlockedTorrentsAreRejected(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01 fake", "Title S01E01"}})()
+
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Block(store.BlockedTorrent{Title: "Title S01E01 fake"}))
+	torrents.UseStore(s)
+	defer torrents.UseStore(nil)
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+	matches, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(matches))
+	assert.Equal(t, "Title S01E01", matches[0].Title)
+
+	entries, _ := s.History(store.HistoryFilter{})
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "blocked", entries[0].Reason)
+}
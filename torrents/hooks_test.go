@@ -0,0 +1,89 @@
+package torrents_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/hooks"
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+type fakeEngine struct {
+	titles []string
+}
+
+func (f fakeEngine) Name() string { return "fake" }
+
+func (f fakeEngine) Search(context.Context, string) ([]torrents.Torrent, error) {
+	var found []torrents.Torrent
+	for _, title := range f.titles {
+		URL, _ := url.Parse("http://example.com/" + title)
+		found = append(found, torrents.Torrent{URL: URL, Title: title})
+	}
+	return found, nil
+}
+
+// withEngines replaces the search engines, call the returned func to restore
+// them.
+func withEngines(replacements ...torrents.SearchEngine) func() {
+	original := map[string]torrents.SearchEngine{}
+	for name, engine := range torrents.SearchEngines {
+		original[name] = engine
+		delete(torrents.SearchEngines, name)
+	}
+	for _, engine := range replacements {
+		torrents.SearchEngines[engine.Name()] = engine
+	}
+	return func() {
+		for name := range torrents.SearchEngines {
+			delete(torrents.SearchEngines, name)
+		}
+		for name, engine := range original {
+			torrents.SearchEngines[name] = engine
+		}
+	}
+}
+
+func TestPreSnatchHookVetoes(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01 fake", "Title S01E01"}})()
+
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	defer hooks.Reset()
+
+	script := path.Join(dir, "veto.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\ncase \"$GETME_TORRENT\" in *fake*) exit 1;; esac\n"), 0755)
+	hooks.Set(hooks.PreSnatch, script)
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+	matches, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(matches))
+	assert.Equal(t, "Title S01E01", matches[0].Title)
+	assert.Equal(t, "Title", matches[0].Show)
+}
+
+func TestSkippedSearchesArentLearned(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01"}})()
+	defer hooks.Reset()
+	hooks.Set(hooks.PreSearch, "/bin/false")
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+	matches, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	assert.Empty(t, matches)
+	assert.Empty(t, show.QuerySnippets.ForEpisode)
+	assert.Empty(t, show.QuerySnippets.ForSeason)
+}
@@ -5,12 +5,15 @@
 package torrents
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/sources"
 )
 
@@ -32,8 +35,13 @@ func (k Kickass) Name() string {
 	return "kickass"
 }
 
-func (k Kickass) Search(query string) ([]Torrent, error) {
-	req, err := http.NewRequest("GET", k.constructSearchURL(query), nil)
+// Hosts returns the hosts of the search engine and of the torrent cache.
+func (k Kickass) Hosts() []string {
+	return []string{request.HostOf(k.URL), request.HostOf(k.TorCacheURL)}
+}
+
+func (k Kickass) Search(ctx context.Context, query string) ([]Torrent, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", k.constructSearchURL(query), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -64,10 +72,14 @@ func (k Kickass) Search(query string) ([]Torrent, error) {
 			return nil, err
 		}
 		torrent := Torrent{
-			URL:      url,
-			Filename: searchItem.FileName,
-			Title:    searchItem.Title,
-			seeds:    searchItem.Seeds,
+			URL:       url,
+			Filename:  searchItem.FileName,
+			Title:     searchItem.Title,
+			seeds:     searchItem.Seeds,
+			leechers:  searchItem.Peers,
+			Size:      searchItem.ContentLength,
+			Published: parsePubDate(searchItem.PubDate),
+			InfoHash:  strings.ToLower(searchItem.InfoHash),
 		}
 		torrents = append(torrents, torrent)
 	}
@@ -91,6 +103,9 @@ type kickassItem struct {
 	Seeds    int    `xml:"seeds"`
 	Peers    int    `xml:"peers"`
 	FileName string `xml:"fileName"`
+	// ContentLength is the size of the content in bytes.
+	ContentLength int64  `xml:"contentLength"`
+	PubDate       string `xml:"pubDate"`
 }
 
 func (i kickassItem) torrentURL(torCacheURL string) (*url.URL, error) {
@@ -0,0 +1,270 @@
+package torrents
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackpal/bencode-go"
+
+	"github.com/haarts/getme/store"
+)
+
+// Metainfo is the content of a .torrent file.
+type Metainfo struct {
+	// Name is the name of the file, or of the directory, the torrent
+	// downloads to.
+	Name        string
+	Files       []File
+	PieceLength int64
+	// InfoHash is the hex encoded SHA-1 of the info dictionary.
+	InfoHash string
+	Trackers []string
+}
+
+// File is a file in the payload of a torrent.
+type File struct {
+	// Path is the path of the file, starting with the name of the torrent.
+	Path   string
+	Length int64
+}
+
+// Size is the size of the payload in bytes.
+func (m Metainfo) Size() int64 {
+	var size int64
+	for _, f := range m.Files {
+		size += f.Length
+	}
+	return size
+}
+
+// ParseMetainfo parses a .torrent file.
+func ParseMetainfo(data []byte) (Metainfo, error) {
+	decoded, err := bencode.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Metainfo{}, err
+	}
+	torrent, ok := decoded.(map[string]interface{})
+	if !ok {
+		return Metainfo{}, errors.New("torrent is not a dictionary")
+	}
+	info, ok := torrent["info"].(map[string]interface{})
+	if !ok {
+		return Metainfo{}, errors.New("torrent has no info dictionary")
+	}
+
+	raw, err := rawInfo(data)
+	if err != nil {
+		return Metainfo{}, err
+	}
+	hash := sha1.Sum(raw)
+
+	meta := Metainfo{
+		InfoHash:    hex.EncodeToString(hash[:]),
+		PieceLength: integer(info["piece length"]),
+		Trackers:    trackers(torrent),
+	}
+	meta.Name, _ = info["name"].(string)
+	if meta.Name == "" {
+		return Metainfo{}, errors.New("torrent has no name")
+	}
+
+	files, ok := info["files"].([]interface{})
+	if !ok {
+		meta.Files = []File{{Path: meta.Name, Length: integer(info["length"])}}
+		return meta, nil
+	}
+	for _, f := range files {
+		file, ok := f.(map[string]interface{})
+		if !ok {
+			return Metainfo{}, errors.New("torrent has a file which is not a dictionary")
+		}
+		parts := []string{meta.Name}
+		elements, _ := file["path"].([]interface{})
+		for _, element := range elements {
+			part, _ := element.(string)
+			parts = append(parts, part)
+		}
+		meta.Files = append(meta.Files, File{Path: path.Join(parts...), Length: integer(file["length"])})
+	}
+	return meta, nil
+}
+
+func integer(v interface{}) int64 {
+	i, _ := v.(int64)
+	return i
+}
+
+// trackers returns the announce URL and those of the announce list, without
+// duplicates.
+func trackers(torrent map[string]interface{}) []string {
+	var urls []string
+	seen := map[string]bool{}
+	add := func(v interface{}) {
+		u, ok := v.(string)
+		if ok && u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	add(torrent["announce"])
+	tiers, _ := torrent["announce-list"].([]interface{})
+	for _, tier := range tiers {
+		tier, _ := tier.([]interface{})
+		for _, u := range tier {
+			add(u)
+		}
+	}
+	return urls
+}
+
+// rawInfo returns the info dictionary exactly as it is in the torrent, the
+// info hash is the SHA-1 of these bytes.
+func rawInfo(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errors.New("torrent is not a dictionary")
+	}
+	for i := 1; i < len(data) && data[i] != 'e'; {
+		keyEnd, err := skip(data, i)
+		if err != nil {
+			return nil, err
+		}
+		valueEnd, err := skip(data, keyEnd)
+		if err != nil {
+			return nil, err
+		}
+		if string(data[i:keyEnd]) == "4:info" {
+			return data[keyEnd:valueEnd], nil
+		}
+		i = valueEnd
+	}
+	return nil, errors.New("torrent has no info dictionary")
+}
+
+// skip returns where the bencoded value starting at i ends.
+func skip(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	switch c := data[i]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[i:], 'e')
+		if end < 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return i + end + 1, nil
+	case c == 'l' || c == 'd':
+		i++
+		for i < len(data) && data[i] != 'e' {
+			var err error
+			if i, err = skip(data, i); err != nil {
+				return 0, err
+			}
+		}
+		if i >= len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return i + 1, nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[i:], ':')
+		if colon < 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		length, err := strconv.Atoi(string(data[i : i+colon]))
+		if err != nil {
+			return 0, err
+		}
+		end := i + colon + 1 + length
+		if end > len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return end, nil
+	}
+	return 0, fmt.Errorf("unexpected %q at %d in torrent", data[i], i)
+}
+
+// errBadPayload is returned when a torrent doesn't contain what it was
+// downloaded for. Such torrents are blocked.
+var errBadPayload = errors.New("bad payload")
+
+var (
+	videoFile      = regexp.MustCompile(`(?i)\.(mkv|mp4|m4v|avi|mov|wmv|mpe?g|ts|webm)$`)
+	sampleFile     = regexp.MustCompile(`(?i)\bsample\b`)
+	archiveFile    = regexp.MustCompile(`(?i)\.(rar|r\d\d|zip|7z)$`)
+	executableFile = regexp.MustCompile(`(?i)\.(exe|scr|bat|cmd|com|msi|lnk|vbs|jar)$`)
+	// The metainfo doesn't tell whether a rar is encrypted, but fakes come
+	// with a note or a link telling where to get the password.
+	passwordFile = regexp.MustCompile(`(?i)(\bpass(word)?s?\b|\.url$)`)
+)
+
+// verifyPayload checks that the files of a torrent are what the media it
+// was downloaded for needs: video files for every episode, named like
+// S01E02, by absolute number, by air date or, for specials, by title, no
+// executables and no archives in place of video.
+func verifyPayload(meta Metainfo, media Doner) error {
+	var archives, passwords bool
+	found := map[episodeNumber]bool{}
+	absolute := map[int]bool{}
+	var dates []time.Time
+	var videos []string
+	for _, f := range meta.Files {
+		name := path.Base(f.Path)
+		switch {
+		case executableFile.MatchString(name):
+			return fmt.Errorf("%w: contains the executable %s", errBadPayload, f.Path)
+		case archiveFile.MatchString(name):
+			archives = true
+		case videoFile.MatchString(name) && !sampleFile.MatchString(name):
+			videos = append(videos, name)
+			for _, episode := range episodesIn(name) {
+				found[episode] = true
+			}
+			if release, ok := parseAnimeRelease(name); ok {
+				for n := release.from; n <= release.to; n++ {
+					absolute[n] = true
+				}
+			}
+			dates = append(dates, datesIn(name)...)
+		}
+		if passwordFile.MatchString(name) {
+			passwords = true
+		}
+	}
+
+	if archives && passwords {
+		return fmt.Errorf("%w: contains a password protected archive", errBadPayload)
+	}
+	if len(videos) == 0 {
+		if archives {
+			return fmt.Errorf("%w: contains only archives", errBadPayload)
+		}
+		return fmt.Errorf("%w: contains no video", errBadPayload)
+	}
+
+	var expected []*store.Episode
+	switch m := media.(type) {
+	case *store.Episode:
+		expected = []*store.Episode{m}
+	case *store.Season:
+		expected = m.PendingEpisodes()
+	case Pack:
+		expected = m
+	case Series:
+		expected = m.episodes()
+	}
+	for _, e := range expected {
+		if !found[episodeNumber{e.Season(), e.Episode}] && !absolute[e.Absolute] && !containsDate(dates, e.AirDate) && !namesSpecial(videos, e) {
+			return fmt.Errorf("%w: no video for S%02dE%02d", errBadPayload, e.Season(), e.Episode)
+		}
+	}
+	return nil
+}
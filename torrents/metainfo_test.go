@@ -0,0 +1,184 @@
+package torrents_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+// metainfo bencodes a torrent with the files, by path and length, and
+// returns it with its bencoded info dictionary.
+func metainfo(name string, files map[string]int) (string, string) {
+	var list []string
+	for p, length := range files {
+		var parts string
+		for _, part := range strings.Split(p, "/") {
+			parts += fmt.Sprintf("%d:%s", len(part), part)
+		}
+		list = append(list, fmt.Sprintf("d6:lengthi%de4:pathl%see", length, parts))
+	}
+	info := fmt.Sprintf("d5:filesl%se4:name%d:%s12:piece lengthi262144e6:pieces0:e", strings.Join(list, ""), len(name), name)
+	return "d8:announce9:udp://one13:announce-listll9:udp://oneel9:udp://twoee4:info" + info + "e", info
+}
+
+func TestParseMetainfo(t *testing.T) {
+	torrent, info := metainfo("Show.S01", map[string]int{"Show.S01E01.mkv": 300, "Subs/en.srt": 2})
+
+	meta, err := torrents.ParseMetainfo([]byte(torrent))
+	require.NoError(t, err)
+
+	hash := sha1.Sum([]byte(info))
+	assert.Equal(t, hex.EncodeToString(hash[:]), meta.InfoHash)
+	assert.Equal(t, "Show.S01", meta.Name)
+	assert.Equal(t, int64(262144), meta.PieceLength)
+	assert.Equal(t, []string{"udp://one", "udp://two"}, meta.Trackers)
+	assert.ElementsMatch(t, []torrents.File{
+		{Path: "Show.S01/Show.S01E01.mkv", Length: 300},
+		{Path: "Show.S01/Subs/en.srt", Length: 2},
+	}, meta.Files)
+	assert.Equal(t, int64(302), meta.Size())
+}
+
+func TestParseSingleFileMetainfo(t *testing.T) {
+	meta, err := torrents.ParseMetainfo([]byte("d4:infod6:lengthi42e4:name8:Movie.mpee"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []torrents.File{{Path: "Movie.mp", Length: 42}}, meta.Files)
+	assert.Empty(t, meta.Trackers)
+}
+
+func TestParseInvalidMetainfo(t *testing.T) {
+	for _, torrent := range []string{"not really a torrent", "le", "d4:infod4:name1:xe", "d4:infoi1ee"} {
+		_, err := torrents.ParseMetainfo([]byte(torrent))
+		assert.Error(t, err, torrent)
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	season := &store.Season{Season: 1, Episodes: []*store.Episode{
+		{Episode: 1, Pending: true},
+		{Episode: 2, Pending: true},
+		{Episode: 3},
+	}}
+	episode := season.PendingEpisodes()[1]
+
+	for _, test := range []struct {
+		files  []string
+		media  torrents.Doner
+		reason string
+	}{
+		{[]string{"Show.S01E02.720p.mkv"}, episode, ""},
+		{[]string{"Show.1x02.avi", "Show.nfo"}, episode, ""},
+		{[]string{"Show.S01E01E02.mkv"}, episode, ""},
+		{[]string{"Show.S01E01.mkv", "Show.S01E02.mkv"}, season, ""},
+		{[]string{"Movie.mp4"}, &store.Movie{}, ""},
+		{[]string{"[Group] Show - 14 [1080p].mkv"}, &store.Episode{Episode: 2, Absolute: 14}, ""},
+		{[]string{"Show.S01E01.mkv"}, episode, "no video for S01E02"},
+		{[]string{"Show.S01E01.mkv", "Show.S01E03.mkv"}, season, "no video for S01E02"},
+		{[]string{"Show.S01E02.sample.mkv"}, episode, "contains no video"},
+		{[]string{"Show.S01E02.rar", "Show.S01E02.r00"}, episode, "contains only archives"},
+		{[]string{"Show.S01E02.mkv", "Codec.exe"}, episode, "contains the executable"},
+		{[]string{"Show.S01E02.mkv", "Show.S01E02.rar", "Password.txt"}, episode, "password protected archive"},
+	} {
+		meta := torrents.Metainfo{Name: "Show"}
+		for _, f := range test.files {
+			meta.Files = append(meta.Files, torrents.File{Path: path.Join("Show", f), Length: 1})
+		}
+
+		err := torrents.VerifyPayload(meta, test.media)
+		if test.reason == "" {
+			assert.NoError(t, err, "%v", test.files)
+			continue
+		}
+		if assert.Error(t, err, "%v", test.files) {
+			assert.Contains(t, err.Error(), test.reason)
+		}
+	}
+}
+
+func TestDownloadBlocksBadPayload(t *testing.T) {
+	mux, ts := Setup(t)
+	defer ts.Close()
+
+	torrent, _ := metainfo("Show.S01E01", map[string]int{"Show.S01E01.rar": 300})
+	mux.HandleFunc("/fake.torrent", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, torrent)
+	})
+
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	torrents.UseStore(s)
+	defer torrents.UseStore(nil)
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	u, _ := url.Parse(ts.URL + "/fake.torrent")
+	fake := torrents.Torrent{
+		URL:             u,
+		Title:           "Show S01E01",
+		Filename:        "fake.torrent",
+		AssociatedMedia: season.PendingEpisodes()[0],
+	}
+
+	err = torrents.Download(context.Background(), []torrents.Torrent{fake}, dir)
+	assert.Error(t, err)
+
+	_, err = os.Stat(path.Join(dir, "fake.torrent"))
+	assert.True(t, os.IsNotExist(err), "nothing is written to the watch dir")
+	assert.True(t, season.Episodes[0].Pending)
+	assert.True(t, s.IsBlocked("", "Show S01E01"))
+}
+
+func TestDownloadFailureIsFoundByTheParsedInfoHash(t *testing.T) {
+	mux, ts := Setup(t)
+	defer ts.Close()
+
+	torrent, info := metainfo("Show.S01E01", map[string]int{"Show.S01E01.mkv": 300})
+	mux.HandleFunc("/show.s01e01.torrent", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, torrent)
+	})
+	hash := sha1.Sum([]byte(info))
+	infoHash := hex.EncodeToString(hash[:])
+
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, "shows"), 0755)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+
+	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
+	show := &store.Show{Title: "Show", Seasons: []*store.Season{&season}}
+	s.CreateShow(show)
+	u, _ := url.Parse(ts.URL + "/show.s01e01.torrent")
+	found := []torrents.Torrent{{
+		URL:             u,
+		Title:           "Show S01E01",
+		Filename:        "show.s01e01.torrent",
+		Show:            "Show",
+		AssociatedMedia: season.PendingEpisodes()[0],
+	}}
+
+	require.NoError(t, torrents.Download(context.Background(), found, dir))
+	torrents.RecordSnatches(context.Background(), s, show, found, time.Now())
+
+	_, err = s.DownloadFailed(strings.ToUpper(infoHash), "", "dead", time.Now())
+	require.NoError(t, err)
+	assert.True(t, season.Episodes[0].Pending, "wanted again")
+	assert.True(t, s.IsBlocked(infoHash, "Show S01E01 PROPER"), "blocked by info hash")
+}
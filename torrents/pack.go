@@ -0,0 +1,159 @@
+package torrents
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/haarts/getme/store"
+)
+
+// Pack is a torrent's worth of episodes of one season, like a double
+// episode 'S01E01E02' or a partial season 'S02E01-E05'.
+type Pack []*store.Episode
+
+// Done marks every episode in the pack as done.
+func (p Pack) Done() {
+	for _, e := range p {
+		e.Done()
+	}
+}
+
+// packCoverage is the part of the pending episodes of a season a pack has to
+// contain to be preferred over downloading the episodes one by one.
+const packCoverage = 0.5
+
+type episodeNumber struct {
+	season, episode int
+}
+
+var (
+	// episodesPattern matches S01E02, S01E02E03, S02E01-E05, S02E01-05 and
+	// 1x02.
+	episodesPattern = regexp.MustCompile(`(?i)(?:\bs(\d{1,2})[ ._-]?e(\d{1,3})((?:[ ._]?-[ ._]?e?\d{1,3}\b|[ ._-]?e\d{1,3}\b)*)|\b(\d{1,2})x(\d{2,3})\b)`)
+	moreEpisodes    = regexp.MustCompile(`(?i)(-)?[ ._]?e?(\d{1,3})`)
+)
+
+// episodesIn returns the episodes a title or a file name says it contains.
+func episodesIn(name string) []episodeNumber {
+	var episodes []episodeNumber
+	for _, match := range episodesPattern.FindAllStringSubmatch(name, -1) {
+		if match[4] != "" {
+			season, _ := strconv.Atoi(match[4])
+			episode, _ := strconv.Atoi(match[5])
+			episodes = append(episodes, episodeNumber{season, episode})
+			continue
+		}
+
+		season, _ := strconv.Atoi(match[1])
+		last, _ := strconv.Atoi(match[2])
+		episodes = append(episodes, episodeNumber{season, last})
+		for _, more := range moreEpisodes.FindAllStringSubmatch(match[3], -1) {
+			episode, _ := strconv.Atoi(more[2])
+			from := episode
+			if more[1] != "" {
+				from = last + 1
+			}
+			for e := from; e <= episode; e++ {
+				episodes = append(episodes, episodeNumber{season, e})
+			}
+			last = episode
+		}
+	}
+	return episodes
+}
+
+// without returns the episodes which aren't in exclude.
+func without(episodes []*store.Episode, exclude map[*store.Episode]bool) []*store.Episode {
+	var remaining []*store.Episode
+	for _, e := range episodes {
+		if !exclude[e] {
+			remaining = append(remaining, e)
+		}
+	}
+	return remaining
+}
+
+// isEpisode rejects torrents for episode jobs which name episodes, but not
+// the one searched for. Torrents which don't name any episode pass.
+func isEpisode(job queryJob, title string) bool {
+	episode, ok := job.media.(*store.Episode)
+	if !ok {
+		return true
+	}
+	if release, ok := job.animeRelease(title); ok && episode.Absolute != 0 {
+		return release.from <= episode.Absolute && episode.Absolute <= release.to
+	}
+	if episode.Season() == 0 {
+		return isSpecialRelease(episode, title)
+	}
+	if dates := datesIn(title); job.daily && len(dates) > 0 && !episode.AirDate.IsZero() {
+		return containsDate(dates, episode.AirDate)
+	}
+	found := episodesIn(title)
+	for _, n := range found {
+		if n.season == episode.Season() && n.episode == episode.Episode {
+			return true
+		}
+	}
+	return len(found) == 0
+}
+
+// packOf returns the pending episodes a torrent found for an episode job
+// contains, nil when it doesn't contain more than the episode searched for.
+func packOf(job queryJob, torrent Torrent) Pack {
+	if _, ok := job.media.(*store.Episode); !ok {
+		return nil
+	}
+
+	var pack Pack
+	if release, ok := job.animeRelease(torrent.Title); ok {
+		pack = byAbsolute(job.pending, release.from, release.to)
+	} else {
+		contains := map[episodeNumber]bool{}
+		for _, n := range episodesIn(torrent.Title) {
+			contains[n] = true
+		}
+		for _, e := range job.pending {
+			if contains[episodeNumber{e.Season(), e.Episode}] {
+				pack = append(pack, e)
+			}
+		}
+	}
+	if len(pack) < 2 {
+		return nil
+	}
+	return pack
+}
+
+// mediaOf returns what a torrent found for a job is for: the pack it
+// contains, or the media of the job.
+func mediaOf(job queryJob, torrent Torrent) Doner {
+	if pack := packOf(job, torrent); pack != nil {
+		return pack
+	}
+	return job.media
+}
+
+// preferPacks moves the best pack which contains enough of the pending
+// episodes of its season to the front, one download being better than many.
+func preferPacks(job queryJob, torrents []Torrent) {
+	for i, torrent := range torrents {
+		pack := packOf(job, torrent)
+		if pack == nil {
+			continue
+		}
+
+		var pending int
+		for _, e := range job.pending {
+			if e.Season() == pack[0].Season() {
+				pending++
+			}
+		}
+		if float64(len(pack)) < packCoverage*float64(pending) {
+			continue
+		}
+		copy(torrents[1:i+1], torrents[:i])
+		torrents[0] = torrent
+		return
+	}
+}
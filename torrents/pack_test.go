@@ -0,0 +1,155 @@
+package torrents_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+func TestEpisodesIn(t *testing.T) {
+	for title, expected := range map[string][][2]int{
+		"Show S01E02 720p":         {{1, 2}},
+		"Show.S01E01E02.HDTV":      {{1, 1}, {1, 2}},
+		"Show S02E01-E05 1080p":    {{2, 1}, {2, 2}, {2, 3}, {2, 4}, {2, 5}},
+		"Show S02E08-10":           {{2, 8}, {2, 9}, {2, 10}},
+		"Show.S03E01.E02.E03.mkv":  {{3, 1}, {3, 2}, {3, 3}},
+		"Show 4x07":                {{4, 7}},
+		"Show season 1 complete":   nil,
+		"Show S01 E05 - 1080 HEVC": {{1, 5}},
+	} {
+		assert.Equal(t, expected, torrents.EpisodesIn(title), title)
+	}
+}
+
+// recordSnatches records the snatches of the downloaded torrents in a new
+// store and returns them, the first first.
+func recordSnatches(t *testing.T, show *store.Show, downloaded []torrents.Torrent) []store.HistoryEntry {
+	dir, _ := ioutil.TempDir("", "getme")
+	defer os.RemoveAll(dir)
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+
+	torrents.RecordSnatches(context.Background(), s, show, downloaded, time.Now())
+	snatches, err := s.History(store.HistoryFilter{Action: store.Snatched})
+	require.NoError(t, err)
+	return snatches
+}
+
+func TestSearchPrefersPacks(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E02", "Title S01E02-E04"}})()
+
+	season := store.Season{1, []*store.Episode{
+		{Episode: 1},
+		{Pending: true, Episode: 2},
+		{Pending: true, Episode: 3},
+		{Pending: true, Episode: 4},
+		{Pending: true, Episode: 5},
+	}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(found), "the pack covers episodes 3 and 4 and nothing is found for 5")
+	assert.Equal(t, "Title S01E02-E04", found[0].Title)
+	pack, ok := found[0].AssociatedMedia.(torrents.Pack)
+	require.True(t, ok)
+	assert.Equal(t, 3, len(pack))
+
+	pack.Done()
+	assert.False(t, season.Episodes[3].Pending)
+	assert.True(t, season.Episodes[4].Pending)
+
+	snatches := recordSnatches(t, &show, found)
+	require.Equal(t, 3, len(snatches))
+	assert.Equal(t, 2, snatches[0].Episode)
+	assert.Equal(t, 4, snatches[2].Episode)
+}
+
+func TestSearchSkipsSmallPacks(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01", "Title S01E01E02 FRENCH", "Title S01E01E02"}})()
+
+	var episodes []*store.Episode
+	for i := 1; i <= 10; i++ {
+		episodes = append(episodes, &store.Episode{Pending: i < 10, Episode: i})
+	}
+	season := store.Season{1, episodes}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, found)
+	assert.Equal(t, "Title S01E01", found[0].Title, "2 of 9 pending episodes isn't enough")
+}
+
+func TestPacksDontRepeatEpisodes(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title S01E01", "Title S01E01E02"}})()
+
+	season := store.Season{1, []*store.Episode{
+		{Pending: true, Episode: 1},
+		{Pending: true, Episode: 2},
+		{Episode: 3},
+	}}
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(found))
+	assert.Equal(t, "Title S01E01E02", found[0].Title)
+	assert.Equal(t, 2, len(found[0].AssociatedMedia.(torrents.Pack)))
+}
+
+func TestSearchAnime(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"[Group] Title - 14 [1080p]", "[Group] Title - 13 [720p]"}})()
+
+	season := store.Season{2, []*store.Episode{
+		{Pending: true, Episode: 1, Absolute: 13},
+		{Pending: true, Episode: 2, Absolute: 14},
+		{Pending: true, Episode: 3, Absolute: 15},
+	}}
+	show := store.Show{Title: "Title", URL: "url", Anime: true, Seasons: []*store.Season{
+		{1, []*store.Episode{{Episode: 1, Absolute: 1}}},
+		&season,
+	}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(found))
+	titles := map[int]string{}
+	for _, torrent := range found {
+		titles[torrent.AssociatedMedia.(*store.Episode).Absolute] = torrent.Title
+	}
+	assert.Equal(t, "[Group] Title - 13 [720p]", titles[13])
+	assert.Equal(t, "[Group] Title - 14 [1080p]", titles[14])
+}
+
+func TestSearchAnimeBatch(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"[Group] Title - 13-15 [Batch]"}})()
+
+	season := store.Season{2, []*store.Episode{
+		{Pending: true, Episode: 1, Absolute: 13},
+		{Pending: true, Episode: 2, Absolute: 14},
+		{Pending: true, Episode: 3, Absolute: 15},
+	}}
+	show := store.Show{Title: "Title", URL: "url", Anime: true, Seasons: []*store.Season{
+		{1, []*store.Episode{{Episode: 1, Absolute: 1}}},
+		&season,
+	}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(found))
+	assert.Len(t, found[0].AssociatedMedia, 3)
+}
@@ -0,0 +1,341 @@
+package torrents
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/store"
+)
+
+// Preferences tune the scoring and the filtering of torrents.
+type Preferences struct {
+	// Qualities are the wanted qualities, like "1080p" or "hdtv", the most
+	// wanted first.
+	Qualities []string
+	// ReleaseGroups are the preferred release groups, like "KILLERS".
+	ReleaseGroups []string
+	// FansubGroups are the preferred release groups for anime, like
+	// "SubsPlease". Without them ReleaseGroups are used for anime as well.
+	FansubGroups []string
+	// Trust weighs the search engines, by name, from 0 to 1. Engines which
+	// aren't listed are fully trusted.
+	Trust map[string]float64
+	// SizeLimits holds the sizes torrents must have, keyed by 'episode',
+	// 'season' or 'movie'. The limits of seasons are per episode in the
+	// pack. A key followed by a quality, like 'episode_1080p', holds the
+	// limits for torrents of that quality.
+	SizeLimits map[string]SizeLimit
+}
+
+// SizeLimit bounds the size of torrents in bytes. Zero means unbounded.
+type SizeLimit struct {
+	Min, Max int64
+}
+
+var preferences = struct {
+	sync.RWMutex
+	Preferences
+}{}
+
+// Prefer sets the preferences torrents are scored and filtered with.
+func Prefer(p Preferences) {
+	preferences.Lock()
+	defer preferences.Unlock()
+	preferences.Preferences = p
+}
+
+func currentPreferences() Preferences {
+	preferences.RLock()
+	defer preferences.RUnlock()
+	return preferences.Preferences
+}
+
+// Score rates how well a torrent fits a search, from 0 to 100.
+type Score struct {
+	Total   float64
+	Factors []Factor
+}
+
+// Factor is one of the things a score is made of. Its value, from 0 to 1,
+// counts Weight times in the total.
+type Factor struct {
+	Name   string
+	Value  float64
+	Weight float64
+}
+
+// String lists the total and every factor, for the logs and the dry run.
+func (s Score) String() string {
+	parts := make([]string, len(s.Factors))
+	for i, f := range s.Factors {
+		parts[i] = fmt.Sprintf("%s %.2f×%g", f.Name, f.Value, f.Weight)
+	}
+	return fmt.Sprintf("%.1f (%s)", s.Total, strings.Join(parts, ", "))
+}
+
+type factor struct {
+	name   string
+	weight float64
+	value  func(queryJob, Torrent, Preferences) float64
+}
+
+// factors make up the score of a torrent. A factor which can't tell, for
+// example because the size of a torrent is unknown, is 0.5.
+var factors = []factor{
+	{"seeders", 3, seedersFactor},
+	{"ratio", 1, ratioFactor},
+	{"size", 1.5, sizeFactor},
+	{"quality", 2, qualityFactor},
+	{"release_group", 1, releaseGroupFactor},
+	{"similarity", 2, similarityFactor},
+	{"trust", 1, trustFactor},
+	{"age", 0.5, ageFactor},
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+func score(job queryJob, torrent Torrent, p Preferences) Score {
+	var s Score
+	var total, weights float64
+	for _, f := range factors {
+		value := math.Max(0, math.Min(1, f.value(job, torrent, p)))
+		s.Factors = append(s.Factors, Factor{Name: f.name, Value: value, Weight: f.weight})
+		total += value * f.weight
+		weights += f.weight
+	}
+	s.Total = 100 * total / weights
+	return s
+}
+
+// rank scores the torrents found for a job and sorts them, the best first.
+func rank(job queryJob, torrents []Torrent) {
+	p := currentPreferences()
+	for i := range torrents {
+		torrents[i].Score = score(job, torrents[i], p)
+		log.WithFields(log.Fields{
+			"job":   job.query,
+			"title": torrents[i].Title,
+			"score": torrents[i].Score.String(),
+		}).Debug("Scored torrent")
+	}
+	sort.Stable(byScore(torrents))
+}
+
+// seedersFactor grows with the number of seeders, 1000 or more is best.
+func seedersFactor(_ queryJob, t Torrent, _ Preferences) float64 {
+	return math.Log10(1+float64(t.seeds)) / 3
+}
+
+// ratioFactor prefers torrents with more seeders than leechers.
+func ratioFactor(_ queryJob, t Torrent, _ Preferences) float64 {
+	if t.seeds+t.leechers == 0 {
+		return 0
+	}
+	return float64(t.seeds) / float64(t.seeds+t.leechers)
+}
+
+// defaultRuntime is the runtime, in minutes, of episodes whose runtime is
+// unknown.
+const defaultRuntime = 45
+
+// megabytesPerMinute are the plausible sizes of a minute of video per
+// resolution, the last one is for standard definition.
+var megabytesPerMinute = []struct {
+	resolution *regexp.Regexp
+	min, max   float64
+}{
+	{regexp.MustCompile(`(?i)\b(2160p|4k|uhd)\b`), 25, 200},
+	{regexp.MustCompile(`(?i)\b1080[pi]\b`), 10, 60},
+	{regexp.MustCompile(`(?i)\b720p\b`), 5, 30},
+	{regexp.MustCompile(``), 2, 15},
+}
+
+// sizeFactor tells whether the size of a torrent is plausible for the
+// runtime of what it is for, given its resolution.
+func sizeFactor(job queryJob, t Torrent, _ Preferences) float64 {
+	if t.Size <= 0 {
+		return 0.5
+	}
+
+	megabytes := float64(t.Size) / (1024 * 1024) / runtimeOf(mediaOf(job, t))
+	for _, plausible := range megabytesPerMinute {
+		if !plausible.resolution.MatchString(t.Title) {
+			continue
+		}
+		if megabytes < plausible.min {
+			return megabytes / plausible.min
+		}
+		if megabytes > plausible.max {
+			return plausible.max / megabytes
+		}
+		break
+	}
+	return 1
+}
+
+// runtimeOf returns the runtime, in minutes, of an episode, a pack, a season
+// or a series.
+func runtimeOf(media Doner) float64 {
+	runtime := func(e *store.Episode) float64 {
+		if e.Runtime == 0 {
+			return defaultRuntime
+		}
+		return float64(e.Runtime)
+	}
+
+	switch m := media.(type) {
+	case *store.Episode:
+		return runtime(m)
+	case *store.Season:
+		var total float64
+		for _, e := range m.Episodes {
+			total += runtime(e)
+		}
+		if total > 0 {
+			return total
+		}
+	case Pack, Series:
+		var total float64
+		for _, e := range episodesOf(m) {
+			total += runtime(e)
+		}
+		if total > 0 {
+			return total
+		}
+	}
+	return defaultRuntime
+}
+
+// qualityFactor prefers the qualities wanted most.
+func qualityFactor(_ queryJob, t Torrent, p Preferences) float64 {
+	if len(p.Qualities) == 0 {
+		return 0.5
+	}
+	for i, quality := range p.Qualities {
+		if containsWord(t.Title, quality) {
+			return 1 - float64(i)/float64(len(p.Qualities))
+		}
+	}
+	return 0
+}
+
+var releaseGroupPattern = regexp.MustCompile(`-([A-Za-z0-9]+)(\[[^\]]*\])?$`)
+
+var fansubGroupPattern = regexp.MustCompile(`^\[([^\]]+)\]`)
+
+// releaseGroup returns the group which released a torrent, like KILLERS in
+// 'Show S01E01 HDTV x264-KILLERS[ettv]' or Group in
+// '[Group] Title - 123 [1080p]'.
+func releaseGroup(title string) string {
+	title = strings.TrimSpace(title)
+	if matches := releaseGroupPattern.FindStringSubmatch(title); matches != nil {
+		return matches[1]
+	}
+	if matches := fansubGroupPattern.FindStringSubmatch(title); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
+
+// releaseGroupFactor prefers the preferred release groups, or the preferred
+// fansub groups for anime.
+func releaseGroupFactor(job queryJob, t Torrent, p Preferences) float64 {
+	groups := p.ReleaseGroups
+	if job.anime && len(p.FansubGroups) > 0 {
+		groups = p.FansubGroups
+	}
+	if len(groups) == 0 {
+		return 0.5
+	}
+	group := releaseGroup(t.Title)
+	for _, preferred := range groups {
+		if strings.EqualFold(group, preferred) {
+			return 1
+		}
+	}
+	return 0
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+func words(s string) []string {
+	return strings.Fields(nonWord.ReplaceAllString(strings.ToLower(s), " "))
+}
+
+func containsWord(title, word string) bool {
+	needle := " " + strings.Join(words(word), " ") + " "
+	return needle != "  " && strings.Contains(" "+strings.Join(words(title), " ")+" ", needle)
+}
+
+// similarityFactor is the part of the words of the query found in the
+// title of the torrent. The query with any other title of the show in place
+// of the title snippet counts as well, so releases under an alternate title
+// aren't penalized.
+func similarityFactor(job queryJob, t Torrent, _ Preferences) float64 {
+	best := similarity(job.query, t.Title)
+	if job.snippet.TitleSnippet == "" {
+		return best
+	}
+	for _, title := range job.titles {
+		query := strings.Replace(job.query, job.snippet.TitleSnippet, title, 1)
+		best = math.Max(best, similarity(query, t.Title))
+	}
+	return best
+}
+
+func similarity(q, torrentTitle string) float64 {
+	query := words(q)
+	if len(query) == 0 {
+		return 0.5
+	}
+
+	title := map[string]bool{}
+	for _, word := range words(torrentTitle) {
+		title[word] = true
+	}
+	var found int
+	for _, word := range query {
+		if title[word] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(query))
+}
+
+// trustFactor weighs the search engine which found the torrent.
+func trustFactor(_ queryJob, t Torrent, p Preferences) float64 {
+	if trust, ok := p.Trust[t.Engine]; ok {
+		return trust
+	}
+	return 1
+}
+
+// ageFactor prefers recent torrents, which are more likely to still be
+// alive. The value halves every year.
+func ageFactor(_ queryJob, t Torrent, _ Preferences) float64 {
+	if t.Published.IsZero() {
+		return 0.5
+	}
+	years := now().Sub(t.Published).Hours() / 24 / 365
+	return math.Pow(0.5, years)
+}
+
+// Sort highest score on top, the one with the most seeds on a tie.
+type byScore []Torrent
+
+func (a byScore) Len() int      { return len(a) }
+func (a byScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScore) Less(i, j int) bool {
+	if a[i].Score.Total != a[j].Score.Total {
+		return a[i].Score.Total > a[j].Score.Total
+	}
+	return a[i].seeds > a[j].seeds
+}
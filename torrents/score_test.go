@@ -0,0 +1,114 @@
+package torrents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haarts/getme/store"
+)
+
+func TestReleaseGroup(t *testing.T) {
+	assert.Equal(t, "KILLERS", releaseGroup("Fear The Walking Dead S01E01 HDTV x264-KILLERS[ettv]"))
+	assert.Equal(t, "DIMENSION", releaseGroup("Show.S01E01.720p.HDTV.x264-DIMENSION"))
+	assert.Equal(t, "", releaseGroup("Show S01E01"))
+}
+
+func TestQualityFactor(t *testing.T) {
+	p := Preferences{Qualities: []string{"1080p", "720p"}}
+	job := queryJob{}
+
+	assert.Equal(t, 1.0, qualityFactor(job, Torrent{Title: "Show.S01E01.1080p.WEB-DL"}, p))
+	assert.Equal(t, 0.5, qualityFactor(job, Torrent{Title: "Show S01E01 720p HDTV"}, p))
+	assert.Equal(t, 0.0, qualityFactor(job, Torrent{Title: "Show S01E01 HDTV"}, p))
+	assert.Equal(t, 0.5, qualityFactor(job, Torrent{Title: "Show S01E01 HDTV"}, Preferences{}))
+}
+
+func TestSizeFactor(t *testing.T) {
+	job := queryJob{media: &store.Episode{Runtime: 60}}
+	megabytes := int64(1024 * 1024)
+
+	assert.Equal(t, 0.5, sizeFactor(job, Torrent{Title: "Show S01E01 720p"}, Preferences{}), "unknown size")
+	assert.Equal(t, 1.0, sizeFactor(job, Torrent{Title: "Show S01E01 720p", Size: 1200 * megabytes}, Preferences{}))
+	assert.Equal(t, 0.5, sizeFactor(job, Torrent{Title: "Show S01E01 720p", Size: 150 * megabytes}, Preferences{}))
+	assert.InDelta(t, 0.25, sizeFactor(job, Torrent{Title: "Show S01E01", Size: 3600 * megabytes}, Preferences{}), 0.001)
+
+	pending := (&store.Season{Season: 1, Episodes: []*store.Episode{
+		{Pending: true, Episode: 1, Runtime: 60}, {Pending: true, Episode: 2, Runtime: 60},
+	}}).PendingEpisodes()
+	pack := queryJob{media: pending[0], pending: pending}
+	assert.Equal(t, 1.0, sizeFactor(pack, Torrent{Title: "Show S01E01E02 720p", Size: 2400 * megabytes}, Preferences{}), "the runtime of the pack")
+}
+
+func TestSimilarityFactor(t *testing.T) {
+	job := queryJob{
+		query:   "Marvels Agents of SHIELD S01E01",
+		snippet: store.Snippet{TitleSnippet: "Marvels Agents of SHIELD"},
+		titles:  []string{"Marvel's Agents of S.H.I.E.L.D.", "Agents of SHIELD"},
+	}
+
+	assert.Equal(t, 1.0, similarityFactor(job, Torrent{Title: "Marvels.Agents.of.SHIELD.S01E01.720p"}, Preferences{}))
+	assert.Equal(t, 1.0, similarityFactor(job, Torrent{Title: "Agents.of.SHIELD.S01E01.720p"}, Preferences{}), "alternate title")
+	assert.Equal(t, 0.75, similarityFactor(job, Torrent{Title: "Agents.of.Nothing.S01E01.720p"}, Preferences{}), "the best of the titles")
+}
+
+func TestTitleSnippets(t *testing.T) {
+	show := &store.Show{Title: "Title", AlternateTitles: []string{"Other: Title"}}
+
+	assert.Equal(t, []string{"Title", "Other: Title", "Other Title"}, titleSnippets(show))
+}
+
+func TestRank(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC) }
+	defer Prefer(Preferences{})
+	Prefer(Preferences{Qualities: []string{"720p"}, Trust: map[string]float64{"shady": 0}})
+
+	megabytes := int64(1024 * 1024)
+	job := queryJob{query: "Show S01E01", media: &store.Episode{Runtime: 45}}
+	torrents := []Torrent{
+		{Title: "Show S01E01 720p HDTV x264-FAKE", seeds: 2000, Size: 1 * megabytes, Engine: "shady"},
+		{Title: "Show S01E01 720p HDTV x264-KILLERS", seeds: 300, leechers: 20, Size: 800 * megabytes, Engine: "kickass"},
+		{Title: "Other S01E01 HDTV", seeds: 300, Size: 300 * megabytes, Engine: "kickass"},
+	}
+	rank(job, torrents)
+
+	assert.Equal(t, "Show S01E01 720p HDTV x264-KILLERS", torrents[0].Title)
+	assert.Equal(t, "Show S01E01 720p HDTV x264-FAKE", torrents[1].Title)
+	assert.Len(t, torrents[0].Score.Factors, len(factors))
+	assert.True(t, torrents[0].Score.Total <= 100)
+	assert.Contains(t, torrents[0].Score.String(), "quality 1.00×2")
+}
+
+func TestIsWithinSizeLimits(t *testing.T) {
+	defer Prefer(Preferences{})
+	Prefer(Preferences{SizeLimits: map[string]SizeLimit{
+		"episode":       {Min: 100, Max: 1000},
+		"episode_1080p": {Min: 500, Max: 3000},
+		"season":        {Min: 100},
+	}})
+
+	episode := queryJob{media: &store.Episode{}}
+	assert.True(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01", Size: 800}))
+	assert.False(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01", Size: 50}))
+	assert.False(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01", Size: 2000}))
+	assert.True(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01", Size: 0}), "unknown size")
+	assert.True(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01 1080p", Size: 2000}), "quality wins")
+	assert.False(t, isWithinSizeLimits(episode, Torrent{Title: "Show S01E01 1080p", Size: 200}), "quality wins")
+
+	season := queryJob{media: &store.Season{Episodes: []*store.Episode{{}, {}, {}}}}
+	assert.False(t, isWithinSizeLimits(season, Torrent{Title: "Show season 1", Size: 200}), "scaled by episodes")
+	assert.True(t, isWithinSizeLimits(season, Torrent{Title: "Show season 1", Size: 300}))
+	assert.True(t, isWithinSizeLimits(season, Torrent{Title: "Show season 1", Size: 1 << 40}))
+
+	assert.True(t, isWithinSizeLimits(queryJob{media: &store.Movie{}}, Torrent{Title: "Movie", Size: 1}), "no movie limits")
+
+	pending := (&store.Season{Season: 1, Episodes: []*store.Episode{
+		{Pending: true, Episode: 1}, {Pending: true, Episode: 2}, {Pending: true, Episode: 3},
+	}}).PendingEpisodes()
+	pack := queryJob{media: pending[0], pending: pending}
+	assert.True(t, isWithinSizeLimits(pack, Torrent{Title: "Show S01E01-E03", Size: 2500}), "scaled by the episodes in the pack")
+	assert.False(t, isWithinSizeLimits(pack, Torrent{Title: "Show S01E01-E03", Size: 250}))
+	assert.False(t, isWithinSizeLimits(pack, Torrent{Title: "Show S01E01", Size: 2500}))
+}
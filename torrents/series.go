@@ -0,0 +1,114 @@
+package torrents
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/haarts/getme/store"
+)
+
+// Series is every season of a show, for torrents of the complete series.
+type Series []*store.Season
+
+// Done marks every season of the series as done.
+func (s Series) Done() {
+	for _, season := range s {
+		season.Done()
+	}
+}
+
+// span returns the first and the last season of the series.
+func (s Series) span() (int, int) {
+	if len(s) == 0 {
+		return 0, 0
+	}
+	first, last := s[0].Season, s[0].Season
+	for _, season := range s {
+		if season.Season < first {
+			first = season.Season
+		}
+		if season.Season > last {
+			last = season.Season
+		}
+	}
+	return first, last
+}
+
+// episodes returns the pending episodes of every season.
+func (s Series) episodes() []*store.Episode {
+	var episodes []*store.Episode
+	for _, season := range s {
+		episodes = append(episodes, season.PendingEpisodes()...)
+	}
+	return episodes
+}
+
+// seriesOf returns the seasons of a show which ended and of which nothing
+// was downloaded yet, nil for other shows. Specials don't count.
+func seriesOf(show *store.Show) Series {
+	if show.Ended == nil || !*show.Ended {
+		return nil
+	}
+
+	var series Series
+	for _, season := range show.Seasons {
+		if season.Season != 0 {
+			series = append(series, season)
+		}
+	}
+	var pending int
+	for _, season := range show.PendingSeasons() {
+		if season.Season != 0 {
+			pending++
+		}
+	}
+	if len(series) < 2 || pending != len(series) {
+		return nil
+	}
+	return series
+}
+
+var (
+	// seasonRange matches 'S01-S07', 'S01-07', 'seasons 1-7' and
+	// 'season 1 to 7'.
+	seasonRange = regexp.MustCompile(`\bs(?:easons?)?[ .]?(\d{1,2})[ .]?(?:-|to)[ .]?(?:s(?:easons?)?[ .]?)?(\d{1,2})\b`)
+	complete    = regexp.MustCompile(`\b(complete|box ?set|collection)\b`)
+	oneSeason   = regexp.MustCompile(`\b(s\d{1,2}|seasons? ?\d{1,2})\b`)
+)
+
+// isCompleteSeries rejects torrents for a complete series which only hold
+// some of its seasons. Other jobs pass.
+func isCompleteSeries(job queryJob, title string) bool {
+	series, ok := job.media.(Series)
+	if !ok {
+		return true
+	}
+
+	lowerCase := strings.ToLower(title)
+	if matches := seasonRange.FindStringSubmatch(lowerCase); matches != nil {
+		start, _ := strconv.Atoi(matches[1])
+		end, _ := strconv.Atoi(matches[2])
+		first, last := series.span()
+		return start <= first && end >= last
+	}
+	return complete.MatchString(lowerCase) && !oneSeason.MatchString(lowerCase)
+}
+
+func queriesForSeries(show *store.Show) []queryJob {
+	series := seriesOf(show)
+	if series == nil {
+		return nil
+	}
+
+	snippet, explored := selectSeriesSnippet(show)
+
+	query := seriesQueryAlternatives[snippet.FormatSnippet](snippet.TitleSnippet, series)
+	return []queryJob{{
+		show:     show.Title,
+		snippet:  snippet,
+		query:    query,
+		media:    series,
+		explored: explored,
+	}}
+}
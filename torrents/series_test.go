@@ -0,0 +1,92 @@
+package torrents_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+func TestIsCompleteSeries(t *testing.T) {
+	seasons := []int{1, 2, 3}
+	for title, expected := range map[string]bool{
+		"Title Complete Series 720p":  true,
+		"Title S01-S03 1080p":         true,
+		"Title S01-03 x264":           true,
+		"Title Seasons 1-5 Box Set":   true,
+		"Title season 1 to 3":         true,
+		"Title The Complete Box Set":  true,
+		"Title S01-S02":               false,
+		"Title Season 2 Complete":     false,
+		"Title S03 COMPLETE":          false,
+		"Title season 1":              false,
+		"Title S01E01":                false,
+		"Title Complete Collection!!": true,
+	} {
+		assert.Equal(t, expected, torrents.IsCompleteSeries(seasons, title), title)
+	}
+}
+
+// endedShow returns a show which ended, with n seasons of two pending
+// episodes.
+func endedShow(n int) store.Show {
+	ended := true
+	show := store.Show{Title: "Title", URL: "url", Ended: &ended}
+	for i := 1; i <= n; i++ {
+		show.Seasons = append(show.Seasons, &store.Season{i, []*store.Episode{
+			{Pending: true, Episode: 1},
+			{Pending: true, Episode: 2},
+		}})
+	}
+	return show
+}
+
+func TestSearchCompleteSeries(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title season 1", "Title S01-S03 Complete"}})()
+
+	show := endedShow(3)
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(found), "the seasons aren't searched for one by one")
+	assert.Equal(t, "Title S01-S03 Complete", found[0].Title)
+	require.IsType(t, torrents.Series{}, found[0].AssociatedMedia)
+	assert.Len(t, show.QuerySnippets.ForSeries, 1)
+
+	found[0].AssociatedMedia.Done()
+	assert.Empty(t, show.PendingSeasons())
+	snatches := recordSnatches(t, &show, found)
+	require.Equal(t, 3, len(snatches))
+	assert.Equal(t, 0, snatches[2].Episode)
+	assert.Equal(t, 3, snatches[2].Season)
+}
+
+func TestSearchFallsBackToSeasons(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title season 1", "Title season 2", "Title S01-S02"}})()
+
+	show := endedShow(3)
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(found))
+	for _, torrent := range found {
+		assert.IsType(t, &store.Season{}, torrent.AssociatedMedia)
+	}
+}
+
+func TestRunningShowsAreNotSearchedAsSeries(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title Complete Series"}})()
+
+	show := endedShow(3)
+	show.Ended = nil
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	for _, torrent := range found {
+		assert.IsType(t, &store.Episode{}, torrent.AssociatedMedia, "the last season is searched by episode")
+	}
+}
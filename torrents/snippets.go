@@ -2,12 +2,9 @@ package torrents
 
 import (
 	"fmt"
-	"math/rand"
 	"regexp"
 	"strings"
 
-	log "github.com/Sirupsen/logrus"
-
 	"github.com/haarts/getme/store"
 )
 
@@ -17,6 +14,83 @@ var seasonQueryAlternatives = map[string]func(string, *store.Season) string{
 	},
 }
 
+var seriesQueryAlternatives = map[string]func(string, Series) string{
+	"%s complete series": func(title string, _ Series) string {
+		return fmt.Sprintf("%s complete series", title)
+	},
+	"%s complete": func(title string, _ Series) string {
+		return fmt.Sprintf("%s complete", title)
+	},
+	"%s S%02d-S%02d": func(title string, series Series) string {
+		first, last := series.span()
+		return fmt.Sprintf("%s S%02d-S%02d", title, first, last)
+	},
+	"%s seasons %d-%d": func(title string, series Series) string {
+		first, last := series.span()
+		return fmt.Sprintf("%s seasons %d-%d", title, first, last)
+	},
+	"%s box set": func(title string, _ Series) string {
+		return fmt.Sprintf("%s box set", title)
+	},
+}
+
+// absoluteQueryAlternatives are for anime only, whose releases are named
+// after the absolute numbers of the episodes.
+var absoluteQueryAlternatives = map[string]func(string, *store.Episode) string{
+	"%s %02d": func(title string, episode *store.Episode) string {
+		return fmt.Sprintf("%s %02d", title, episode.Absolute)
+	},
+}
+
+// dailyQueryAlternatives are for daily shows only, whose releases are named
+// after the air dates of the episodes.
+var dailyQueryAlternatives = map[string]func(string, *store.Episode) string{
+	"%s %d %02d %02d": func(title string, episode *store.Episode) string {
+		y, m, d := episode.AirDate.Date()
+		return fmt.Sprintf("%s %d %02d %02d", title, y, m, d)
+	},
+	"%s %d.%02d.%02d": func(title string, episode *store.Episode) string {
+		y, m, d := episode.AirDate.Date()
+		return fmt.Sprintf("%s %d.%02d.%02d", title, y, m, d)
+	},
+}
+
+// episodeAlternatives returns the query formats to search the episodes of a
+// show with.
+func episodeAlternatives(show *store.Show) map[string]func(string, *store.Episode) string {
+	if show.IsDaily() {
+		return dailyQueryAlternatives
+	}
+	if !show.Anime {
+		return episodeQueryAlternatives
+	}
+	alternatives := map[string]func(string, *store.Episode) string{}
+	for k, v := range episodeQueryAlternatives {
+		alternatives[k] = v
+	}
+	for k, v := range absoluteQueryAlternatives {
+		alternatives[k] = v
+	}
+	return alternatives
+}
+
+// episodeQuery formats the query for an episode of a show with a snippet.
+// Formats the show isn't searched with, like absolute numbers after anime
+// was turned off, and episodes without an absolute number fall back to
+// S01E02, or to the air date for daily shows.
+func episodeQuery(show *store.Show, snippet store.Snippet, episode *store.Episode) string {
+	format, ok := episodeAlternatives(show)[snippet.FormatSnippet]
+	if _, absolute := absoluteQueryAlternatives[snippet.FormatSnippet]; absolute && episode.Absolute == 0 {
+		ok = false
+	}
+	if !ok && show.IsDaily() {
+		format = dailyQueryAlternatives["%s %d.%02d.%02d"]
+	} else if !ok {
+		format = episodeQueryAlternatives["%s S%02dE%02d"]
+	}
+	return format(snippet.TitleSnippet, episode)
+}
+
 var episodeQueryAlternatives = map[string]func(string, *store.Episode) string{
 	"%s S%02dE%02d": func(title string, episode *store.Episode) string {
 		return fmt.Sprintf("%s S%02dE%02d", title, episode.Season(), episode.Episode)
@@ -24,12 +98,6 @@ var episodeQueryAlternatives = map[string]func(string, *store.Episode) string{
 	"%s %dx%d": func(title string, episode *store.Episode) string {
 		return fmt.Sprintf("%s %dx%d", title, episode.Season(), episode.Episode)
 	},
-	// This is a bit of a gamble. I, now, no longer make the
-	// distinction between a daily series and a regular one:
-	"%s %d %02d %02d": func(title string, episode *store.Episode) string {
-		y, m, d := episode.AirDate.Date()
-		return fmt.Sprintf("%s %d %02d %02d", title, y, m, d)
-	},
 }
 
 var titleMorphers = [...]func(string) string{
@@ -52,63 +120,51 @@ var titleMorphers = [...]func(string) string{
 	},
 }
 
-func selectEpisodeSnippet(show *store.Show) store.Snippet {
-	if len(show.QuerySnippets.ForEpisode) == 0 || isExplore() {
-		// select random snippet
-		var snippets []store.Snippet
-		for k, _ := range episodeQueryAlternatives {
-			for _, morpher := range titleMorphers {
-				snippets = append(
-					snippets,
-					store.Snippet{
-						Score:         0,
-						TitleSnippet:  morpher(show.Title),
-						FormatSnippet: k,
-					},
-				)
+// titleSnippets returns the titles of a show, the alternate titles included,
+// morphed in every way, without duplicates.
+func titleSnippets(show *store.Show) []string {
+	var snippets []string
+	seen := map[string]bool{}
+	for _, title := range show.Titles() {
+		for _, morpher := range titleMorphers {
+			snippet := morpher(title)
+			if !seen[snippet] {
+				seen[snippet] = true
+				snippets = append(snippets, snippet)
 			}
 		}
-		snippet := snippets[rand.Intn(len(snippets))]
-		log.WithFields(
-			log.Fields{
-				"title_snippet":  snippet.TitleSnippet,
-				"format_snippet": snippet.FormatSnippet,
-			}).Debug("Random snippet")
-		return snippet
 	}
-
-	// select the current best
-	return show.BestEpisodeSnippet()
+	return snippets
 }
 
-func selectSeasonSnippet(show *store.Show) store.Snippet {
-	if len(show.QuerySnippets.ForSeason) == 0 || isExplore() {
-		// select random snippet
-		var snippets []store.Snippet
-		for k, _ := range seasonQueryAlternatives {
-			for _, morpher := range titleMorphers {
-				snippets = append(
-					snippets,
-					store.Snippet{
-						Score:         0,
-						TitleSnippet:  morpher(show.Title),
-						FormatSnippet: k,
-					},
-				)
-			}
-		}
-		return snippets[rand.Intn(len(snippets))]
+// selectEpisodeSnippet returns the snippet to query an episode with and
+// whether it was chosen to explore, see selectSnippet.
+func selectEpisodeSnippet(show *store.Show) (store.Snippet, bool) {
+	var formats []string
+	for format := range episodeAlternatives(show) {
+		formats = append(formats, format)
 	}
+	return selectSnippet(show, store.EpisodeSnippets, formats)
+}
 
-	// select the current best
-	return show.BestSeasonSnippet()
+// selectSeasonSnippet returns the snippet to query a season with and whether
+// it was chosen to explore, see selectSnippet.
+func selectSeasonSnippet(show *store.Show) (store.Snippet, bool) {
+	var formats []string
+	for format := range seasonQueryAlternatives {
+		formats = append(formats, format)
+	}
+	return selectSnippet(show, store.SeasonSnippets, formats)
 }
 
-func isExplore() bool {
-	if rand.Intn(10) == 0 { // explore
-		return true
+// selectSeriesSnippet returns the snippet to query a complete series with and
+// whether it was chosen to explore, see selectSnippet.
+func selectSeriesSnippet(show *store.Show) (store.Snippet, bool) {
+	var formats []string
+	for format := range seriesQueryAlternatives {
+		formats = append(formats, format)
 	}
-	return false
+	return selectSnippet(show, store.SeriesSnippets, formats)
 }
 
 func truncateToNParts(title string, n int) string {
@@ -0,0 +1,62 @@
+package torrents
+
+import (
+	"fmt"
+
+	"github.com/haarts/getme/store"
+)
+
+// isSpecial tells whether media is an episode of season 0.
+func isSpecial(media Doner) bool {
+	episode, ok := media.(*store.Episode)
+	return ok && episode.Season() == 0
+}
+
+// queryForSpecial returns the job to search a special with. Releases of
+// specials rarely follow the numbering of the source, so specials are
+// searched for by title when they have one and by S00E05 otherwise. The
+// snippets learned for regular episodes don't apply.
+func queryForSpecial(show *store.Show, special *store.Episode, pending []*store.Episode) queryJob {
+	query := fmt.Sprintf("%s S00E%02d", show.Title, special.Episode)
+	if special.Title != "" {
+		query = fmt.Sprintf("%s %s", show.Title, special.Title)
+	}
+	return queryJob{
+		show:    show.Title,
+		snippet: store.Snippet{TitleSnippet: show.Title},
+		query:   query,
+		media:   special,
+		pending: pending,
+	}
+}
+
+// isSpecialRelease tells whether a title is that of a release of a special:
+// it names the special like S00E05, by its title or by its air date.
+func isSpecialRelease(special *store.Episode, title string) bool {
+	if special.Title != "" && containsWord(title, special.Title) {
+		return true
+	}
+	if containsDate(datesIn(title), special.AirDate) {
+		return true
+	}
+	for _, n := range episodesIn(title) {
+		if n.season == 0 && n.episode == special.Episode {
+			return true
+		}
+	}
+	return false
+}
+
+// namesSpecial tells whether one of the file names names a special by its
+// title.
+func namesSpecial(names []string, special *store.Episode) bool {
+	if special.Season() != 0 || special.Title == "" {
+		return false
+	}
+	for _, name := range names {
+		if containsWord(name, special.Title) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package torrents_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+func TestSearchSpecials(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{
+		"Title S03E05 720p HDTV",
+		"Title Christmas Special 720p HDTV",
+	}})()
+
+	show := store.Show{Title: "Title", URL: "url", Specials: true, Seasons: []*store.Season{
+		{0, []*store.Episode{{Pending: true, Episode: 1, Title: "Christmas Special"}}},
+		{1, []*store.Episode{{Episode: 1}}},
+	}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(found))
+	assert.Equal(t, "Title Christmas Special 720p HDTV", found[0].Title)
+	assert.Equal(t, 0, len(show.QuerySnippets.ForEpisode), "specials don't teach snippets")
+}
+
+func TestSpecialsAreNotSearchedByDefault(t *testing.T) {
+	defer withEngines(fakeEngine{titles: []string{"Title Christmas Special 720p HDTV"}})()
+
+	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{
+		{0, []*store.Episode{{Pending: true, Episode: 1, Title: "Christmas Special"}}},
+		{1, []*store.Episode{{Episode: 1}}},
+	}}
+
+	found, err := torrents.Search(context.Background(), &show)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(found))
+}
@@ -1,10 +1,13 @@
 package torrents
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/sources"
 
 	log "github.com/Sirupsen/logrus"
@@ -32,6 +35,8 @@ type torrentProjectSearchResult struct {
 type torrentProjectItem struct {
 	Title       string `json:"title"`
 	Seeds       int    `json:"seeds"`
+	Leechers    int    `json:"leechs"`
+	Size        int64  `json:"torrent_size"`
 	TorrentHash string `json:"torrent_hash"`
 }
 
@@ -46,8 +51,14 @@ func (t TorrentProject) Name() string {
 	return "torrentproject"
 }
 
-func (t TorrentProject) Search(query string) ([]Torrent, error) {
-	req, err := http.NewRequest(
+// Hosts returns the hosts of the search engine and of the torrent cache.
+func (t TorrentProject) Hosts() []string {
+	return []string{request.HostOf(t.URL), request.HostOf(t.TorCacheURL)}
+}
+
+func (t TorrentProject) Search(ctx context.Context, query string) ([]Torrent, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		fmt.Sprintf(t.URL+"/?s=%s&out=json&orderby=seeds", query),
 		nil,
@@ -85,6 +96,9 @@ func (t TorrentProject) Search(query string) ([]Torrent, error) {
 			Filename: item.Title + ".torrent",
 			Title:    item.Title,
 			seeds:    item.Seeds,
+			leechers: item.Leechers,
+			Size:     item.Size,
+			InfoHash: strings.ToLower(item.TorrentHash),
 		}
 	}
 
@@ -1,6 +1,7 @@
 package torrents_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -28,7 +29,7 @@ func TestTorrentProjectSearch(t *testing.T) {
 		TorCacheURL: "%s",
 	}
 
-	results, err := torrentProject.Search("foo")
+	results, err := torrentProject.Search(context.Background(), "foo")
 	require.NoError(t, err)
 	require.Len(t, results, 10)
 	assert.Equal(t, "Udemy - Ubuntu Desktop for Beginners - Start Using Linux Today!", results[0].Title)
@@ -1,15 +1,18 @@
 package torrents
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/request"
 	"github.com/haarts/getme/sources"
 )
 
@@ -27,8 +30,15 @@ func (t TorrentCD) Name() string {
 	return "torrentCD"
 }
 
-func (t TorrentCD) Search(query string) ([]Torrent, error) {
-	req, err := http.NewRequest(
+// Hosts returns the host of the search engine, torrents are downloaded from
+// the same host.
+func (t TorrentCD) Hosts() []string {
+	return []string{request.HostOf(t.URL)}
+}
+
+func (t TorrentCD) Search(ctx context.Context, query string) ([]Torrent, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		fmt.Sprintf(t.URL+"/torrents/xml?q=%s", url.QueryEscape(query)),
 		nil,
@@ -55,10 +65,13 @@ func (t TorrentCD) Search(query string) ([]Torrent, error) {
 		}
 
 		torrent := Torrent{
-			URL:      url,
-			Title:    item.Title,
-			Filename: item.Filename(),
-			seeds:    item.Seed,
+			URL:       url,
+			Title:     item.Title,
+			Filename:  item.Filename(),
+			seeds:     item.Seed,
+			leechers:  item.Leech,
+			Size:      item.Size,
+			Published: item.published(),
 		}
 		torrents = append(torrents, torrent)
 	}
@@ -76,12 +89,23 @@ type torrentCDItem struct {
 	Link  string `xml:"link"`
 	Title string `xml:"title"`
 	Seed  int    `xml:"seed"`
+	Leech int    `xml:"leech"`
+	Size  int64  `xml:"size"`
+	// Created is a unix timestamp.
+	Created int64 `xml:"created"`
 }
 
 func (t torrentCDItem) torrentURL() (*url.URL, error) {
 	return url.Parse(strings.Replace(t.Link, "http://torrentcd.me/", "http://torrentcd.me/torrents/download/", 1))
 }
 
+func (t torrentCDItem) published() time.Time {
+	if t.Created == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t.Created, 0)
+}
+
 func (t torrentCDItem) Filename() string {
 	url, _ := url.Parse(t.Link)
 	return path.Base(url.Path)
@@ -1,6 +1,7 @@
 package torrents_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -29,10 +30,12 @@ func TestTorrentCDSearch(t *testing.T) {
 		URL: ts.URL,
 	}
 
-	results, err := torrentCD.Search("foo")
+	results, err := torrentCD.Search(context.Background(), "foo")
 	require.NoError(t, err)
 	require.Len(t, results, 100)
 	assert.Equal(t, "Fear The Walking Dead S01E01 HDTV x264-KILLERS[ettv]", results[0].Title)
+	assert.Equal(t, int64(495571600), results[0].Size)
+	assert.Equal(t, int64(1440505265), results[0].Published.Unix())
 }
 
 func Setup(t *testing.T) (*http.ServeMux, *httptest.Server) {
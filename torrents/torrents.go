@@ -3,6 +3,8 @@
 package torrents
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net/url"
@@ -15,6 +17,8 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/haarts/getme/hooks"
+	"github.com/haarts/getme/metrics"
 	"github.com/haarts/getme/store"
 )
 
@@ -24,21 +28,54 @@ const batchSize = 50
 
 var searchTimeout = 3 * time.Second
 
+var (
+	engineSearches = metrics.NewCounter(
+		"getme_engine_searches_total",
+		"Searches sent to a torrent search engine.",
+		"engine", "result")
+	queryResults = metrics.NewHistogram(
+		"getme_query_results",
+		"Torrents left after filtering the results of a query.",
+		[]float64{0, 1, 2, 5, 10, 20, 50, 100})
+	searchTimeouts = metrics.NewCounter(
+		"getme_search_timeouts_total",
+		"Queries for which not every search engine answered in time.")
+	snippetExplorations = metrics.NewCounter(
+		"getme_snippet_explorations_total",
+		"Queries made with a snippet chosen to explore, by whether a torrent was found.",
+		"media", "result")
+)
+
 // Mark a piece of media as done. Currently only Show.
 type Doner interface {
 	Done()
 }
 
 type Torrent struct {
-	URL             *url.URL
-	Filename        string
-	Title           string
-	seeds           int
+	URL      *url.URL
+	Filename string
+	Title    string
+	seeds    int
+	leechers int
+	// Size is the size of the content in bytes, zero when unknown.
+	Size int64
+	// Published is when the torrent was uploaded, zero when unknown.
+	Published       time.Time
 	AssociatedMedia Doner
+	// Show is the title of the show the torrent was found for.
+	Show string
+	// Engine is the name of the search engine which found the torrent.
+	Engine string
+	// InfoHash is the hex encoded info hash, if known.
+	InfoHash string
+	// Score is how well the torrent fits the search it was found with.
+	Score Score
 }
 
+// SearchEngine finds torrents. The context cancels the requests made on
+// behalf of a search.
 type SearchEngine interface {
-	Search(string) ([]Torrent, error)
+	Search(context.Context, string) ([]Torrent, error)
 	Name() string
 }
 
@@ -49,75 +86,283 @@ var searchEngines = map[string]SearchEngine{
 	"extratorrent":   ExtraTorrent{},
 }
 
+// Hosts returns, per search engine, the hosts the engine sends requests to
+// and downloads torrents from.
+func Hosts() map[string][]string {
+	hosts := map[string][]string{}
+	for name, engine := range searchEngines {
+		h, ok := engine.(interface {
+			Hosts() []string
+		})
+		if !ok {
+			continue
+		}
+		for _, host := range h.Hosts() {
+			if host != "" {
+				hosts[name] = append(hosts[name], host)
+			}
+		}
+	}
+	return hosts
+}
+
 type queryJob struct {
+	show    string
 	media   Doner
 	snippet store.Snippet
 	query   string
-	season  int // to distinguish between episode and season jobs. Nasty hack IMO. FIXME
+	// explored is set when the snippet was chosen to learn more about it
+	// instead of being the best one so far.
+	explored bool
+	// dryRun jobs don't record their decisions in the history.
+	dryRun bool
+	// pending are the pending episodes of the show a torrent found for an
+	// episode job may contain as well, see Pack.
+	pending []*store.Episode
+	// anime jobs also take fansub releases numbered absolutely.
+	anime bool
+	// daily jobs take releases named after the air date of the episode.
+	daily bool
+	// titles are every title of the show, see store.Show.Titles.
+	titles []string
+	season int // to distinguish between episode and season jobs. Nasty hack IMO. FIXME
 }
 
-func Search(show *store.Show) ([]Torrent, error) {
+// details returns what a hook needs to know about the job.
+func (job queryJob) details(hook string) hooks.Details {
+	season, episode := seasonAndEpisode(job.media)
+	return hooks.Details{
+		Hook:    hook,
+		Show:    job.show,
+		Season:  season,
+		Episode: episode,
+		Query:   job.query,
+	}
+}
+
+// seasonAndEpisode returns the numbers of the media a torrent is for. The
+// episode is 0 for seasons and the first episode for packs.
+func seasonAndEpisode(media Doner) (int, int) {
+	switch m := media.(type) {
+	case *store.Season:
+		return m.Season, 0
+	case *store.Episode:
+		return m.Season(), m.Episode
+	case Pack:
+		if len(m) > 0 {
+			return m[0].Season(), m[0].Episode
+		}
+	}
+	return 0, 0
+}
+
+// Search finds torrents for the pending seasons and episodes of a show. When
+// the context is cancelled the torrents found so far are returned together
+// with the context's error.
+func Search(ctx context.Context, show *store.Show) ([]Torrent, error) {
 	// torrents holds the torrents to complete a serie
 	var torrents []Torrent
 
+	// taken holds the episodes torrents were found for so far, alone, in a
+	// pack, a season or a complete series.
+	taken := map[*store.Episode]bool{}
+
 	// TODO perhaps mashing season and episode jobs together is a bad idea
 	queryJobs := createQueryJobs(show)
 	for _, queryJob := range queryJobs {
-		torrent, err := executeJob(queryJob)
+		if ctx.Err() != nil {
+			return torrents, ctx.Err()
+		}
+		if isTaken(queryJob.media, taken) {
+			continue
+		}
+		queryJob.pending = without(queryJob.pending, taken)
+
+		torrent, err := executeJob(ctx, queryJob)
+		// Skipped searches and vetoed torrents say nothing about the
+		// snippet.
+		if ctx.Err() == nil && (err == nil || errors.Is(err, errNoTorrents)) {
+			observeExploration(queryJob, err == nil)
+			learnSnippet(show, queryJob, torrent)
+		}
 		if err != nil {
 			continue
 		}
 
-		torrent.AssociatedMedia = queryJob.media
-		queryJob.snippet.Score = torrent.seeds
-		// *ouch* this type switch is ugly
-		switch queryJob.media.(type) {
-		case *store.Season:
-			show.StoreSeasonSnippet(queryJob.snippet)
-		case *store.Episode:
-			show.StoreEpisodeSnippet(queryJob.snippet)
-		default:
-			panic("unknown media type")
+		torrent.AssociatedMedia = mediaOf(queryJob, *torrent)
+		for _, episode := range episodesOf(torrent.AssociatedMedia) {
+			taken[episode] = true
 		}
+		torrent.Show = show.Title
 		torrents = append(torrents, *torrent)
 	}
 
 	return torrents, nil
 }
 
-func executeJob(job queryJob) (*Torrent, error) {
-	results := searchWithFilters(job, isEnglish, isSeason)
+// episodesOf returns the episodes a torrent for media completes.
+func episodesOf(media Doner) []*store.Episode {
+	switch m := media.(type) {
+	case *store.Episode:
+		return []*store.Episode{m}
+	case Pack:
+		return m
+	case *store.Season:
+		return m.Episodes
+	case Series:
+		var episodes []*store.Episode
+		for _, season := range m {
+			episodes = append(episodes, season.Episodes...)
+		}
+		return episodes
+	}
+	return nil
+}
+
+// isTaken tells whether every episode of media is taken already.
+func isTaken(media Doner, taken map[*store.Episode]bool) bool {
+	episodes := episodesOf(media)
+	for _, episode := range episodes {
+		if !taken[episode] {
+			return false
+		}
+	}
+	return len(episodes) > 0
+}
+
+func observeExploration(job queryJob, found bool) {
+	media := snippetMedia(job)
+	if !job.explored || media == "" {
+		return
+	}
+	result := "miss"
+	if found {
+		result = "hit"
+	}
+	snippetExplorations.Inc(media, result)
+}
+
+// errNoTorrents is returned by executeJob when the engines found nothing.
+var errNoTorrents = errors.New("no torrents found")
 
-	torrents := collectResultsWithTimeout(results)
+// executeJob searches for the torrents of a job and returns the best one.
+// The pre search hook can skip the search and the pre snatch hook can veto
+// torrents, in which case the next best torrent is considered.
+func executeJob(ctx context.Context, job queryJob) (*Torrent, error) {
+	if err := hooks.Run(ctx, job.details(hooks.PreSearch)); err != nil {
+		return nil, err
+	}
 
+	torrents := rankedSearch(ctx, job)
 	if len(torrents) == 0 {
-		return nil, fmt.Errorf("No torrents found for %s", job.query)
+		return nil, fmt.Errorf("%w for %s", errNoTorrents, job.query)
 	}
 
-	sort.Sort(bySeeds(torrents))
-	bestTorrent := torrents[0]
+	for i, candidate := range torrents {
+		details := job.details(hooks.PreSnatch)
+		details.Torrent = candidate.Title
+		details.URL = candidate.URL.String()
+		details.Seeds = candidate.seeds
+		if err := hooks.Run(ctx, details); err != nil {
+			log.WithFields(log.Fields{
+				"torrent_url": candidate.URL,
+				"title":       candidate.Title,
+			}).Info("Torrent vetoed by hook")
+			record(job.historyEntry(store.Vetoed, candidate, err.Error()))
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"torrent_url": candidate.URL,
+			"title":       candidate.Title,
+			"score":       candidate.Score.String(),
+		}).Info("Selected best torrent")
 
-	log.WithFields(log.Fields{
-		"torrent_url": bestTorrent.URL,
-		"title":       bestTorrent.Title,
-		"score":       bestTorrent.seeds,
-	}).Info("Selected best torrent")
+		entries := []store.HistoryEntry{job.historyEntry(store.Selected, candidate, candidate.Score.String())}
+		for _, other := range torrents[i+1:] {
+			reason := fmt.Sprintf("scored %s, %s scored %.1f", other.Score, candidate.Title, candidate.Score.Total)
+			entries = append(entries, job.historyEntry(store.Outranked, other, reason))
+		}
+		job.record(entries...)
 
-	return &bestTorrent, nil
+		return &candidate, nil
+	}
+
+	return nil, fmt.Errorf("Every torrent found for %s was vetoed", job.query)
 }
 
-func searchWithFilters(job queryJob, filters ...filter) chan []Torrent {
-	// c emits the torrents found for one search request on one search engine
-	c := make(chan []Torrent)
+// rankedSearch searches every search engine for a job and returns the
+// torrents passing the filters, the best first.
+func rankedSearch(ctx context.Context, job queryJob) []Torrent {
+	searchCtx, cancel := context.WithTimeout(ctx, searchTimeout)
+	results := searchWithFilters(searchCtx, job, blocklistFilter, englishFilter, seasonFilter, episodeFilter, seriesFilter, sizeFilter)
+	torrents := collectResultsWithTimeout(searchCtx, results)
+	cancel()
+	queryResults.Observe(float64(len(torrents)))
+
+	rank(job, torrents)
+	preferPacks(job, torrents)
+	return torrents
+}
+
+// Ranking holds the torrents found with one query, the best first.
+type Ranking struct {
+	Query string
+	// Season and Episode are what the query is for, Episode is 0 for
+	// seasons.
+	Season  int
+	Episode int
+	// Series is set when the query is for the complete series.
+	Series   bool
+	Torrents []Torrent
+}
+
+// DryRun searches for the pending seasons and episodes of a show like Search
+// but only returns what was found. Nothing is recorded and no hooks are run.
+func DryRun(ctx context.Context, show *store.Show) ([]Ranking, error) {
+	var rankings []Ranking
+	for _, job := range createQueryJobs(show) {
+		if ctx.Err() != nil {
+			return rankings, ctx.Err()
+		}
+
+		job.dryRun = true
+		season, episode := seasonAndEpisode(job.media)
+		_, series := job.media.(Series)
+		rankings = append(rankings, Ranking{
+			Query:    job.query,
+			Season:   season,
+			Episode:  episode,
+			Series:   series,
+			Torrents: rankedSearch(ctx, job),
+		})
+	}
+	return rankings, nil
+}
+
+func searchWithFilters(ctx context.Context, job queryJob, filters ...filter) chan []Torrent {
+	// c emits the torrents found for one search request on one search engine.
+	// It is buffered so engines answering after the timeout don't block
+	// forever.
+	c := make(chan []Torrent, len(searchEngines))
 	for _, searchEngine := range searchEngines {
 		go func(s SearchEngine) {
-			torrents, err := s.Search(job.query)
+			torrents, err := s.Search(ctx, job.query)
+			for i := range torrents {
+				torrents[i].Engine = s.Name()
+				if torrents[i].InfoHash == "" {
+					torrents[i].InfoHash = infoHashFromURL(torrents[i].URL)
+				}
+			}
 			if err != nil {
 				log.WithFields(log.Fields{
 					"err":           err,
 					"search_engine": s.Name(),
 					"job":           job.query,
 				}).Error("Search engine returned error")
+				engineSearches.Inc(s.Name(), "error")
+			} else {
+				engineSearches.Inc(s.Name(), "success")
 			}
 			torrents = applyFilters(job, torrents, filters...)
 			c <- torrents
@@ -127,43 +372,64 @@ func searchWithFilters(job queryJob, filters ...filter) chan []Torrent {
 	return c
 }
 
-func collectResultsWithTimeout(results chan []Torrent) []Torrent {
+// collectResultsWithTimeout gathers the results of the search engines until
+// every engine answered or the context is done. Cancelling the context also
+// cancels the searches which are still running.
+func collectResultsWithTimeout(ctx context.Context, results chan []Torrent) []Torrent {
 	var torrentsFromAllEngines []Torrent
-	timeout := time.After(searchTimeout)
 	for i := 0; i < len(searchEngines); i++ {
 		select {
 		case result := <-results:
 			torrentsFromAllEngines = append(torrentsFromAllEngines, result...)
-		case <-timeout:
+		case <-ctx.Done():
+			searchTimeouts.Inc()
 			log.WithFields(log.Fields{
 				"found_torrents": len(torrentsFromAllEngines),
 			}).Warn("Torrents search timed out")
+			return torrentsFromAllEngines
 		}
 	}
 
 	return torrentsFromAllEngines
 }
 
+// createQueryJobs returns the jobs to search with. A complete series comes
+// first, its seasons are only searched for one by one when it isn't found.
 func createQueryJobs(show *store.Show) []queryJob {
+	seriesQueries := queriesForSeries(show)
 	seasonQueries := queriesForSeasons(show)
 	episodeQueries := queriesForEpisodes(show)
-	return append(seasonQueries, episodeQueries...)
+	jobs := append(append(seriesQueries, seasonQueries...), episodeQueries...)
+	for i := range jobs {
+		jobs[i].anime = show.Anime
+		jobs[i].daily = show.IsDaily()
+		jobs[i].titles = show.Titles()
+	}
+	return jobs
 }
 
 func queriesForEpisodes(show *store.Show) []queryJob {
 	episodes := show.PendingEpisodes()
 	sort.Sort(store.ByAirDate(episodes))
+	pending := append([]*store.Episode(nil), episodes...)
 	min := math.Min(float64(len(episodes)), float64(batchSize))
 
 	queries := []queryJob{}
 	for _, episode := range episodes[0:int(min)] {
-		snippet := selectEpisodeSnippet(show)
+		if episode.Season() == 0 {
+			queries = append(queries, queryForSpecial(show, episode, pending))
+			continue
+		}
+		snippet, explored := selectEpisodeSnippet(show)
 
-		query := episodeQueryAlternatives[snippet.FormatSnippet](snippet.TitleSnippet, episode)
+		query := episodeQuery(show, snippet, episode)
 		queries = append(queries, queryJob{
-			snippet: snippet,
-			query:   query,
-			media:   episode,
+			show:     show.Title,
+			snippet:  snippet,
+			query:    query,
+			media:    episode,
+			explored: explored,
+			pending:  pending,
 		})
 	}
 	return queries
@@ -172,46 +438,137 @@ func queriesForEpisodes(show *store.Show) []queryJob {
 func queriesForSeasons(show *store.Show) []queryJob {
 	queries := []queryJob{}
 	for _, season := range show.PendingSeasons() {
-		// ignore Season 0, which are specials and are rarely found and/or
-		// interesting.
-		if season.Season == 0 {
-			continue
-		}
-
-		snippet := selectSeasonSnippet(show)
+		snippet, explored := selectSeasonSnippet(show)
 
 		query := seasonQueryAlternatives[snippet.FormatSnippet](snippet.TitleSnippet, season)
 		queries = append(queries, queryJob{
-			snippet: snippet,
-			query:   query,
-			media:   season,
-			season:  season.Season,
+			show:     show.Title,
+			snippet:  snippet,
+			query:    query,
+			media:    season,
+			season:   season.Season,
+			explored: explored,
 		})
 	}
 	return queries
 }
 
-type filter func(queryJob, string) bool
+// filter tells whether a torrent fits a job. The reason explains, in the
+// history, why a torrent was rejected.
+type filter struct {
+	reason string
+	accept func(queryJob, Torrent) bool
+}
+
+// byTitle makes a filter function out of one which only looks at titles.
+func byTitle(accept func(queryJob, string) bool) func(queryJob, Torrent) bool {
+	return func(job queryJob, torrent Torrent) bool {
+		return accept(job, torrent.Title)
+	}
+}
+
+var (
+	blocklistFilter = filter{"blocked", isNotBlocked}
+	englishFilter   = filter{"not in English", byTitle(isEnglish)}
+	seasonFilter    = filter{"not the whole season", byTitle(isSeason)}
+	episodeFilter   = filter{"not the episode", byTitle(isEpisode)}
+	seriesFilter    = filter{"not the complete series", byTitle(isCompleteSeries)}
+	sizeFilter      = filter{"size out of limits", isWithinSizeLimits}
+)
 
 // applyFilters takes the original job and the resulting torrents. Then it
 // decides which torrents are really a good fit for the search.
 func applyFilters(job queryJob, torrents []Torrent, filters ...filter) []Torrent {
 	ok := []Torrent{}
+	var rejected []store.HistoryEntry
 	for _, torrent := range torrents {
 		allGood := true
 		for _, f := range filters {
-			if allGood == false {
+			allGood = f.accept(job, torrent)
+			if !allGood {
+				rejected = append(rejected, job.historyEntry(store.Rejected, torrent, f.reason))
 				break
 			}
-			allGood = f(job, torrent.Title)
 		}
 		if allGood {
 			ok = append(ok, torrent)
 		}
 	}
+	job.record(rejected...)
 	return ok
 }
 
+// isNotBlocked rejects the torrents on the blocklist of the used store.
+func isNotBlocked(_ queryJob, torrent Torrent) bool {
+	s := usedStore()
+	return s == nil || !s.IsBlocked(torrent.InfoHash, torrent.Title)
+}
+
+// isWithinSizeLimits rejects torrents which are too small or too large for
+// what they are for. Torrents of unknown size pass.
+func isWithinSizeLimits(job queryJob, torrent Torrent) bool {
+	if torrent.Size <= 0 {
+		return true
+	}
+	limit, ok := sizeLimitFor(mediaOf(job, torrent), torrent.Title, currentPreferences().SizeLimits)
+	if !ok {
+		return true
+	}
+	if limit.Min != 0 && torrent.Size < limit.Min {
+		return false
+	}
+	if limit.Max != 0 && torrent.Size > limit.Max {
+		return false
+	}
+	return true
+}
+
+// sizeLimitFor returns the size limit for a torrent with title for media.
+// The limit of a quality found in the title wins over the one of the media
+// type. The limits of seasons and packs are scaled by their number of
+// episodes.
+func sizeLimitFor(media Doner, title string, limits map[string]SizeLimit) (SizeLimit, bool) {
+	var kind string
+	scale := int64(1)
+	switch m := media.(type) {
+	case *store.Episode:
+		kind = "episode"
+	case Pack:
+		kind = "episode"
+		scale = int64(len(m))
+	case *store.Season:
+		kind = "season"
+		if len(m.Episodes) > 1 {
+			scale = int64(len(m.Episodes))
+		}
+	case Series:
+		kind = "season"
+		if episodes := len(episodesOf(m)); episodes > 1 {
+			scale = int64(episodes)
+		}
+	case *store.Movie:
+		kind = "movie"
+	default:
+		return SizeLimit{}, false
+	}
+
+	var keys []string
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	limit, ok := limits[kind]
+	for _, key := range keys {
+		quality := strings.TrimPrefix(key, kind+"_")
+		if quality != key && containsWord(title, quality) {
+			limit, ok = limits[key], true
+			break
+		}
+	}
+	return SizeLimit{Min: limit.Min * scale, Max: limit.Max * scale}, ok
+}
+
 func isSeason(job queryJob, title string) bool {
 	if job.season == 0 {
 		return true
@@ -279,9 +636,12 @@ func isEnglish(_ queryJob, title string) bool {
 	return isAsciiPrintable(title)
 }
 
-// Sort highest number of seeds on top.
-type bySeeds []Torrent
-
-func (a bySeeds) Len() int           { return len(a) }
-func (a bySeeds) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a bySeeds) Less(i, j int) bool { return a[i].seeds > a[j].seeds }
+// parsePubDate parses the publication date of RSS items, the zero time when
+// it can't.
+func parsePubDate(pubDate string) time.Time {
+	published, err := time.Parse(time.RFC1123Z, pubDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return published
+}
@@ -1,6 +1,7 @@
 package torrents_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -30,7 +31,7 @@ func TestSearchForTorrents(t *testing.T) {
 
 	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
 	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
-	matches, err := torrents.Search(&show)
+	matches, err := torrents.Search(context.Background(), &show)
 	require.NoError(t, err)
 
 	assert.Equal(t, 1, len(matches))
@@ -50,7 +51,7 @@ func Test404(t *testing.T) {
 
 	season := store.Season{1, []*store.Episode{{Pending: true, Episode: 1}}}
 	show := store.Show{Title: "Title", URL: "url", Seasons: []*store.Season{&season}}
-	matches, err := torrents.Search(&show)
+	matches, err := torrents.Search(context.Background(), &show)
 	require.NoError(t, err, "Not finding a torrent is not a big deal. Just continue.")
 
 	assert.Equal(t, 0, len(matches))
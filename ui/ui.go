@@ -3,8 +3,10 @@ package ui
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
@@ -56,6 +58,37 @@ func DisplayPendingEpisodes(show *store.Show) {
 	}
 }
 
+// DisplayHistory lists what was decided about torrents, the oldest first.
+func DisplayHistory(entries []store.HistoryEntry) {
+	if len(entries) == 0 {
+		fmt.Println("Nothing happened yet.")
+		return
+	}
+
+	for _, entry := range entries {
+		media := fmt.Sprintf("S%02d", entry.Season)
+		if entry.Episode != 0 {
+			media += fmt.Sprintf("E%02d", entry.Episode)
+		}
+		details := fmt.Sprintf("%d seeds", entry.Seeds)
+		if entry.Engine != "" {
+			details = entry.Engine + ", " + details
+		}
+		if entry.Reason != "" {
+			details += ", " + entry.Reason
+		}
+		fmt.Printf(
+			"%s %s %s %s: %s (%s)\n",
+			entry.At.Local().Format("2006-01-02 15:04"),
+			entry.Show,
+			media,
+			entry.Action,
+			entry.Torrent,
+			details,
+		)
+	}
+}
+
 // DisplayBestMatchConfirmation asks the user to confirm the, what we THINK, is
 // the best match.
 func DisplayBestMatchConfirmation(matches []sources.SearchResult) sources.Match {
@@ -164,17 +197,17 @@ func createGenerator(ms []sources.Show, step int) func() (string, []interface{})
 
 // Download goes about downloading torrents found based on the pending
 // episodes/seasons.
-func Download(foundTorrents []torrents.Torrent) error {
+func Download(ctx context.Context, foundTorrents []torrents.Torrent) error {
 	fmt.Printf("Downloading %d torrents", len(foundTorrents))
 	c := startProgressBar()
 	defer stopProgressBar(c)
 
-	return torrents.Download(foundTorrents, conf.WatchDir)
+	return torrents.Download(ctx, foundTorrents, conf.WatchDir)
 }
 
 // SearchTorrents provides some feedback to the user and searches for torrents
 // for the pending items.
-func SearchTorrents(show *store.Show) ([]torrents.Torrent, error) {
+func SearchTorrents(ctx context.Context, show *store.Show) ([]torrents.Torrent, error) {
 	fmt.Printf(
 		"Searching for %d torrents",
 		len(show.PendingSeasons())+len(show.PendingEpisodes()),
@@ -183,12 +216,12 @@ func SearchTorrents(show *store.Show) ([]torrents.Torrent, error) {
 	c := startProgressBar()
 	defer stopProgressBar(c)
 
-	return torrents.Search(show)
+	return torrents.Search(ctx, show)
 }
 
 // Search converts a user provided search string into a linked list of
 // potential matches.
-func Search(query string) []sources.SearchResult {
+func Search(ctx context.Context, query string) []sources.SearchResult {
 	fmt.Printf("Seaching for '%s' on: ", query)
 	fmt.Print(strings.Join(sources.SourceNames(), ", "))
 	fmt.Print("\n")
@@ -196,7 +229,7 @@ func Search(query string) []sources.SearchResult {
 	c := startProgressBar()
 	defer stopProgressBar(c)
 
-	matches := sources.Search(query)
+	matches := sources.Search(ctx, query)
 	if isAnyErrorless(matches) { // Silently ignore all errors as long as 1 succeeded.
 		return matches
 	}
@@ -206,37 +239,207 @@ func Search(query string) []sources.SearchResult {
 
 // Lookup takes a show previously selected by the user and finds the seasons
 // and episodes with it.
-func Lookup(show *store.Show) error {
+func Lookup(ctx context.Context, show *store.Show) error {
 	fmt.Printf("Looking up seasons and episodes for '%s'", show.Title)
 	c := startProgressBar()
 	defer stopProgressBar(c)
 
-	return sources.UpdateSeasonsAndEpisodes(show)
+	sources.Link(ctx, show)
+	return sources.UpdateSeasonsAndEpisodes(ctx, show)
+}
+
+// Doctor asks every source a show is linked to for its seasons and reports
+// where they disagree. The episodes themselves are left untouched.
+func Doctor(ctx context.Context, store *store.Store) {
+	shows := store.Shows()
+	var titles []string
+	for title := range shows {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	for _, title := range titles {
+		if ctx.Err() != nil {
+			return
+		}
+
+		show := shows[title]
+		if show.ExternalIDs == nil {
+			sources.Link(ctx, show)
+		}
+
+		var linked []string
+		for name, ID := range show.ExternalIDs {
+			linked = append(linked, fmt.Sprintf("%s:%d", name, ID))
+		}
+		sort.Strings(linked)
+		fmt.Printf("%s [%s]\n", show.Title, strings.Join(linked, ", "))
+
+		_, discrepancies, err := sources.Reconcile(ctx, show)
+		if err != nil {
+			fmt.Printf("  Error: %s\n\n", err.Error())
+			continue
+		}
+		if len(discrepancies) == 0 {
+			fmt.Print("  All sources agree.\n\n")
+			continue
+		}
+		for _, discrepancy := range discrepancies {
+			fmt.Println(" ", discrepancy)
+		}
+		fmt.Print("\n")
+	}
 }
 
+// fullRefreshInterval is how often every show is refreshed from its sources,
+// regardless of whether the sources report it changed.
+const fullRefreshInterval = 7 * 24 * time.Hour
+
 // Update takes all the shows stored on disk and adds any new episodes to them.
-func Update(store *store.Store) {
+// Only the shows which changed since the last update are refreshed from their
+// sources, except once every fullRefreshInterval.
+//
+// Cancelling the context stops the update after the show being worked on.
+// The shows updated so far are kept, the next update picks up the rest. The
+// next update also refreshes the shows which failed to refresh.
+func Update(ctx context.Context, store *store.Store) {
 	fmt.Println("Updating media from sources and downloading pending torrents.")
 
-	updateShows(store.Shows())
+	start := time.Now()
+	updateLog := store.UpdateLog()
+	changes := changedShows(ctx, updateLog, start)
+
+	updateLog.Stale = updateShows(ctx, store, changes, updateLog.Stale)
+	updateLog.LastRun = start
+	if changes == nil {
+		updateLog.LastFullRefresh = start
+	}
 	updateMovies(store.Movies())
 }
 
-func updateShows(shows map[string]*store.Show) {
-	for _, show := range shows {
-		err := updateShow(show)
+// dryRunCandidates is how many torrents the dry run shows per query.
+const dryRunCandidates = 5
+
+// DryRun shows, for every show, the best torrents found for the pending
+// seasons and episodes and how they scored. Nothing is downloaded.
+func DryRun(ctx context.Context, store *store.Store) {
+	fmt.Println("Searching for pending torrents without downloading them.")
+
+	var titles []string
+	for title, show := range store.Shows() {
+		if !show.Paused {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+
+	for _, title := range titles {
+		rankings, err := torrents.DryRun(ctx, store.Shows()[title])
+		displayRankings(title, rankings)
 		if err != nil {
-			fmt.Printf("Error updating '%s': %s\n\n", show.Title, err.Error())
+			fmt.Println("Dry run interrupted.")
+			return
+		}
+	}
+}
+
+func displayRankings(title string, rankings []torrents.Ranking) {
+	for _, ranking := range rankings {
+		media := fmt.Sprintf("season %d", ranking.Season)
+		if ranking.Episode != 0 {
+			media += fmt.Sprintf(" episode %d", ranking.Episode)
+		}
+		if ranking.Series {
+			media = "complete series"
+		}
+		fmt.Printf("%s %s, searched for '%s':\n", title, media, ranking.Query)
+		if len(ranking.Torrents) == 0 {
+			fmt.Println("  Nothing found.")
 			continue
 		}
 
-		torrents, err := SearchTorrents(show)
+		candidates := ranking.Torrents
+		if len(candidates) > dryRunCandidates {
+			candidates = candidates[:dryRunCandidates]
+		}
+		for i, candidate := range candidates {
+			fmt.Printf("  %d. %.1f %s (%s)\n", i+1, candidate.Score.Total, candidate.Title, candidate.Engine)
+			var factors []string
+			for _, f := range candidate.Score.Factors {
+				factors = append(factors, fmt.Sprintf("%s %.2f×%g", f.Name, f.Value, f.Weight))
+			}
+			fmt.Printf("     %s\n", strings.Join(factors, ", "))
+		}
+	}
+}
+
+// changedShows returns which shows changed since the last update. Nil is
+// returned when every show should be refreshed.
+func changedShows(ctx context.Context, updateLog *store.UpdateLog, start time.Time) *sources.Changes {
+	if updateLog.LastRun.IsZero() || start.Sub(updateLog.LastFullRefresh) > fullRefreshInterval {
+		fmt.Println("Refreshing every show.")
+		return nil
+	}
+
+	changes, err := sources.ChangesSince(ctx, updateLog.LastRun)
+	if err != nil {
+		fmt.Printf("Couldn't determine which shows changed, refreshing every show: %s\n", err.Error())
+		return nil
+	}
+	return changes
+}
+
+// updateShows refreshes the shows which changed or are stale and returns the
+// titles of those it didn't refresh, because refreshing them failed or the
+// update was interrupted.
+func updateShows(ctx context.Context, store *store.Store, changes *sources.Changes, stale []string) []string {
+	isStale := map[string]bool{}
+	for _, title := range stale {
+		isStale[title] = true
+	}
+
+	var notRefreshed []string
+	interrupted := false
+	for _, show := range store.Shows() {
+		if show.Paused {
+			if ctx.Err() == nil {
+				fmt.Printf("'%s' is paused.\n", show.Title)
+			}
+			continue
+		}
+
+		refresh := isStale[show.Title] || changes.Changed(show)
+		if ctx.Err() != nil {
+			if !interrupted {
+				fmt.Println("Update interrupted. The shows updated so far are saved.")
+				interrupted = true
+			}
+			if refresh {
+				notRefreshed = append(notRefreshed, show.Title)
+			}
+			continue
+		}
+
+		if refresh {
+			err := updateShow(ctx, show)
+			if err != nil {
+				fmt.Printf("Error updating '%s': %s\n\n", show.Title, err.Error())
+				notRefreshed = append(notRefreshed, show.Title)
+				continue
+			}
+		} else {
+			fmt.Printf("'%s' didn't change since the last update.\n", show.Title)
+		}
+
+		found, err := SearchTorrents(ctx, show)
 		if err != nil {
 			fmt.Printf("Error searching torrents for '%s': %s\n\n", show.Title, err.Error())
 			continue
 		}
 
-		if err := Download(torrents); err != nil {
+		err = Download(ctx, found)
+		torrents.RecordSnatches(ctx, store, show, found, time.Now())
+		if err != nil {
 			fmt.Printf("Error downloading torrents for '%s': %s\n\n", show.Title, err.Error())
 			continue
 		}
@@ -244,15 +447,46 @@ func updateShows(shows map[string]*store.Show) {
 		DisplayPendingEpisodes(show)
 		fmt.Print("\n")
 	}
+	sort.Strings(notRefreshed)
+	return notRefreshed
 }
 
-func updateShow(show *store.Show) error {
+func updateShow(ctx context.Context, show *store.Show) error {
+	if !sources.IsAvailable(show.SourceName) {
+		fmt.Printf(
+			"The source of '%s' (%s) is no longer available. Looking for it elsewhere.\n",
+			show.Title,
+			show.SourceName,
+		)
+		if err := sources.Relink(ctx, show, confirmRelink); err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("Updating '%s'", show.Title)
 
 	c := startProgressBar()
 	defer stopProgressBar(c)
 
-	return sources.UpdateSeasonsAndEpisodes(show)
+	if show.ExternalIDs == nil {
+		sources.Link(ctx, show)
+	}
+	return sources.UpdateSeasonsAndEpisodes(ctx, show)
+}
+
+// confirmRelink asks the user whether a candidate is the show which lost its
+// source. Anything but an explicit yes is a no, we might be run from cron.
+func confirmRelink(show *store.Show, candidate sources.Show) bool {
+	fmt.Printf(
+		"Is '%s' (%d) on %s the same show as '%s'? [y/N] ",
+		candidate.Title,
+		candidate.Year,
+		candidate.Source,
+		show.Title,
+	)
+	line := getUserInput()
+
+	return line == "y" || line == "Y"
 }
 
 // TODO this is easier since we don't have to check for new episodes etc. Just pending.
@@ -0,0 +1,340 @@
+// Package web serves a small web interface to GetMe. It shows the followed
+// shows, their upcoming and wanted episodes and the recent downloads, and
+// allows searching for a show and marking its episodes by hand.
+//
+// The interface is protected with HTTP basic authentication, the password is
+// the API token. The user name is ignored.
+package web
+
+import (
+	"crypto/subtle"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+)
+
+//go:embed templates static
+var assets embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"pending":    pendingOf,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Format("2006-01-02")
+	},
+}).ParseFS(assets, "templates/*.html"))
+
+// maxRows limits the number of episodes listed per table on the index.
+const maxRows = 25
+
+// Handler serves the web interface.
+type Handler struct {
+	store    *store.Store
+	watchDir string
+	token    string
+	static   http.Handler
+	search   func(*http.Request, *store.Show) ([]torrents.Torrent, error)
+	download func(*http.Request, []torrents.Torrent) error
+}
+
+// New returns a handler for the shows in s. Torrents found with a manual
+// search are written to watchDir.
+func New(s *store.Store, watchDir, token string) *Handler {
+	static, _ := fs.Sub(assets, "static")
+	h := &Handler{
+		store:    s,
+		watchDir: watchDir,
+		token:    token,
+		static:   http.StripPrefix("/static/", http.FileServer(http.FS(static))),
+	}
+	h.search = func(r *http.Request, show *store.Show) ([]torrents.Torrent, error) {
+		return torrents.Search(r.Context(), show)
+	}
+	h.download = func(r *http.Request, found []torrents.Torrent) error {
+		return torrents.Download(r.Context(), found, h.watchDir)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="GetMe"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == "POST" && !sameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/" && r.Method == "GET":
+		h.index(w, r)
+	case strings.HasPrefix(r.URL.Path, "/static/"):
+		h.static.ServeHTTP(w, r)
+	case strings.HasPrefix(r.URL.EscapedPath(), "/shows/"):
+		h.routeShow(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// routeShow handles /shows/{title}, /shows/{title}/search and
+// /shows/{title}/episodes/{season}/{episode}. The title is path escaped.
+func (h *Handler) routeShow(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/shows/"), "/")
+	title, err := url.PathUnescape(segments[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.store.Lock()
+	show, ok := h.store.Shows()[title]
+	if !ok {
+		h.store.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if len(segments) == 2 && segments[1] == "search" && r.Method == "POST" {
+		// Searching takes a while, it locks the store only when needed.
+		h.store.Unlock()
+		h.searchShow(w, r, show)
+		return
+	}
+	defer h.store.Unlock()
+
+	switch {
+	case len(segments) == 1 && r.Method == "GET":
+		h.render(w, "show.html", showPage{Show: show, Now: time.Now()})
+	case len(segments) == 4 && segments[1] == "episodes" && r.Method == "POST":
+		h.markEpisode(w, r, show, segments[2], segments[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type episodeRow struct {
+	Show    string
+	Season  int
+	Episode int
+	Title   string
+	AirDate time.Time
+}
+
+// pendingOf counts the pending episodes of the monitored seasons of a show,
+// like the API does.
+func pendingOf(show *store.Show) int {
+	pending := 0
+	for _, season := range show.MonitoredSeasons() {
+		pending += len(season.PendingEpisodes())
+	}
+	return pending
+}
+
+type indexPage struct {
+	Shows    []*store.Show
+	Upcoming []episodeRow
+	Wanted   []episodeRow
+	Snatches []store.HistoryEntry
+}
+
+type showPage struct {
+	Show *store.Show
+	Now  time.Time
+}
+
+func (h *Handler) index(w http.ResponseWriter, r *http.Request) {
+	h.store.Lock()
+	defer h.store.Unlock()
+
+	var page indexPage
+	now := time.Now()
+	for _, show := range h.store.Shows() {
+		page.Shows = append(page.Shows, show)
+		for _, season := range show.MonitoredSeasons() {
+			for _, episode := range season.Episodes {
+				row := episodeRow{
+					Show:    show.Title,
+					Season:  season.Season,
+					Episode: episode.Episode,
+					Title:   episode.Title,
+					AirDate: episode.AirDate,
+				}
+				switch episode.State(now) {
+				case store.EpisodeUpcoming:
+					page.Upcoming = append(page.Upcoming, row)
+				case store.EpisodeWanted:
+					page.Wanted = append(page.Wanted, row)
+				}
+			}
+		}
+	}
+
+	sort.Sort(byTitle(page.Shows))
+	sort.Sort(byAirDate(page.Upcoming))
+	sort.Sort(sort.Reverse(byAirDate(page.Wanted)))
+	page.Upcoming = limit(page.Upcoming)
+	page.Wanted = limit(page.Wanted)
+	snatches, err := h.store.Snatches()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Failed to read the snatches.")
+	}
+	page.Snatches = snatches
+
+	h.render(w, "index.html", page)
+}
+
+// searchShow searches and downloads torrents for the pending episodes of a
+// show right away. Like the daemon it works on a copy of the show, the store
+// isn't locked while searching.
+func (h *Handler) searchShow(w http.ResponseWriter, r *http.Request, show *store.Show) {
+	h.store.Lock()
+	base := show.Copy()
+	copied := show.Copy()
+	h.store.Unlock()
+
+	found, err := h.search(r, copied)
+	if err == nil && len(found) > 0 {
+		err = h.download(r, found)
+		torrents.RecordSnatches(r.Context(), h.store, copied, found, time.Now())
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"show": show.Title,
+		}).Error("Manual search failed.")
+	}
+
+	h.store.Lock()
+	defer h.store.Unlock()
+
+	if current, ok := h.store.Shows()[show.Title]; !ok || current != show {
+		http.NotFound(w, r)
+		return
+	}
+	show.Merge(base, copied)
+	h.save(w, r, show)
+}
+
+// markEpisode sets an episode to downloaded or wanted, as given by the
+// 'state' form value. The store is locked by the caller.
+func (h *Handler) markEpisode(w http.ResponseWriter, r *http.Request, show *store.Show, season, episode string) {
+	s, errSeason := strconv.Atoi(season)
+	e, errEpisode := strconv.Atoi(episode)
+	if errSeason != nil || errEpisode != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	target := findEpisode(show, s, e)
+	if target == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.FormValue("state") {
+	case store.EpisodeDownloaded:
+		target.Done()
+	case store.EpisodeWanted:
+		target.Pending = true
+		target.TriedAt = time.Time{}
+		target.Backoff = 0
+	default:
+		http.Error(w, "Unknown state", http.StatusBadRequest)
+		return
+	}
+
+	h.save(w, r, show)
+}
+
+// save persists a show and sends the browser back to its page.
+func (h *Handler) save(w http.ResponseWriter, r *http.Request, show *store.Show) {
+	if err := h.store.Save(show); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/shows/"+url.PathEscape(show.Title), http.StatusSeeOther)
+}
+
+func (h *Handler) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		log.WithFields(log.Fields{
+			"err":      err,
+			"template": name,
+		}).Error("Failed to render page.")
+	}
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	return ok && h.token != "" && subtle.ConstantTimeCompare([]byte(password), []byte(h.token)) == 1
+}
+
+// sameOrigin guards the forms against being posted from other sites. Posts
+// telling neither their origin nor their referer are refused, the browser
+// sends the password along with them all the same.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func findEpisode(show *store.Show, season, episode int) *store.Episode {
+	for _, s := range show.Seasons {
+		if s.Season != season {
+			continue
+		}
+		for _, e := range s.Episodes {
+			if e.Episode == episode {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func limit(rows []episodeRow) []episodeRow {
+	if len(rows) > maxRows {
+		return rows[:maxRows]
+	}
+	return rows
+}
+
+// Sorts shows alphabetically.
+type byTitle []*store.Show
+
+func (a byTitle) Len() int           { return len(a) }
+func (a byTitle) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTitle) Less(i, j int) bool { return a[i].Title < a[j].Title }
+
+// Sorts the earliest airing episode on top.
+type byAirDate []episodeRow
+
+func (a byAirDate) Len() int           { return len(a) }
+func (a byAirDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAirDate) Less(i, j int) bool { return a[i].AirDate.Before(a[j].AirDate) }
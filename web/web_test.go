@@ -0,0 +1,166 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haarts/getme/store"
+	"github.com/haarts/getme/torrents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "test_state_dir"
+
+func testHandler(t *testing.T) (*Handler, *store.Store) {
+	os.MkdirAll(path.Join(testDir, "shows"), 0755)
+
+	s, err := store.Open(testDir)
+	require.NoError(t, err)
+	s.CreateShow(&store.Show{
+		Title: "Dead Set",
+		Seasons: []*store.Season{{Season: 1, Episodes: []*store.Episode{
+			{Episode: 1, Title: "Episode 1", AirDate: time.Date(2008, 10, 27, 0, 0, 0, 0, time.UTC)},
+			{Episode: 2, Title: "Episode 2", Pending: true, AirDate: time.Date(2008, 10, 28, 0, 0, 0, 0, time.UTC)},
+			{Episode: 3, Title: "Episode 3", Pending: true, AirDate: time.Now().Add(24 * time.Hour)},
+		}}},
+	})
+
+	return New(s, "/tmp", "secret"), s
+}
+
+func do(h *Handler, method, URL string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, URL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://example.com")
+	req.SetBasicAuth("", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestPasswordIsRequired(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	h, _ := testHandler(t)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestPendingOf(t *testing.T) {
+	show := &store.Show{Seasons: []*store.Season{
+		{Season: 0, Episodes: []*store.Episode{{Episode: 1, Pending: true}}},
+		{Season: 1, Episodes: []*store.Episode{{Episode: 1, Pending: true}, {Episode: 2}}},
+	}}
+	assert.Equal(t, 1, pendingOf(show), "specials aren't monitored")
+
+	show.Specials = true
+	assert.Equal(t, 2, pendingOf(show))
+}
+
+func TestIndex(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	h, s := testHandler(t)
+	s.AppendHistory(store.HistoryEntry{Action: store.Snatched, Show: "Dead Set", Season: 1, Episode: 1, Torrent: "Dead.Set.S01E01"})
+
+	w := do(h, "GET", "/", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `href="/shows/Dead%20Set"`)
+	assert.Contains(t, body, "Episode 2", "wanted")
+	assert.Contains(t, body, "Episode 3", "upcoming")
+	assert.Contains(t, body, "Dead.Set.S01E01")
+
+	w = do(h, "GET", "/static/style.css", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMarkEpisode(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	h, s := testHandler(t)
+	episodes := s.Shows()["Dead Set"].Seasons[0].Episodes
+
+	w := do(h, "POST", "/shows/Dead%20Set/episodes/1/2", url.Values{"state": {"downloaded"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.False(t, episodes[1].Pending)
+
+	do(h, "POST", "/shows/Dead%20Set/episodes/1/1", url.Values{"state": {"wanted"}})
+	assert.True(t, episodes[0].Pending)
+
+	w = do(h, "POST", "/shows/Dead%20Set/episodes/1/9", url.Values{"state": {"wanted"}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(h, "GET", "/shows/Dead%20Set", nil)
+	assert.Contains(t, w.Body.String(), "Mark downloaded")
+}
+
+func TestManualSearch(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	h, s := testHandler(t)
+
+	URL, _ := url.Parse("http://example.com/dead.set.s01e02.torrent")
+	h.search = func(_ *http.Request, show *store.Show) ([]torrents.Torrent, error) {
+		locked := make(chan struct{})
+		go func() {
+			s.Lock()
+			s.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(50 * time.Millisecond):
+			t.Error("Expected the store not to be locked while searching.")
+		}
+
+		episode := show.Seasons[0].Episodes[1]
+		return []torrents.Torrent{{URL: URL, Title: "Dead.Set.S01E02", AssociatedMedia: episode}}, nil
+	}
+	h.download = func(_ *http.Request, found []torrents.Torrent) error {
+		for _, t := range found {
+			t.AssociatedMedia.Done()
+		}
+		return nil
+	}
+
+	w := do(h, "POST", "/shows/Dead%20Set/search", nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	snatches, err := s.Snatches()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snatches))
+	assert.Equal(t, "Dead.Set.S01E02", snatches[0].Torrent)
+	assert.False(t, s.Shows()["Dead Set"].Seasons[0].Episodes[1].Pending)
+}
+
+func TestOtherOriginsCantPost(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	h, _ := testHandler(t)
+
+	req := httptest.NewRequest("POST", "/shows/Dead%20Set/search", nil)
+	req.SetBasicAuth("", "secret")
+	req.Header.Set("Origin", "http://evil.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest("POST", "/shows/Dead%20Set/search", nil)
+	req.SetBasicAuth("", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code, "neither origin nor referer")
+
+	req = httptest.NewRequest("POST", "/shows/Dead%20Set/episodes/1/2", strings.NewReader("state=downloaded"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "http://example.com/shows/Dead%20Set")
+	req.SetBasicAuth("", "secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code, "referer only")
+}